@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// backfillOptions describes a historical backfill of the range [start, end)
+type backfillOptions struct {
+	start       time.Time
+	end         time.Time
+	chunk       time.Duration
+	concurrency int
+	checkpoint  string // Optional file recording the progress
+}
+
+// backfillCheckpoint is the progress stored in the checkpoint file. Chunks
+// are written in order, so everything before completed has been emitted.
+type backfillCheckpoint struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Completed time.Time `json:"completed"`
+}
+
+// backfillChunk is the result of querying a single chunk
+type backfillChunk struct {
+	metrics []MetricData
+	err     error
+}
+
+// parseBackfillTime parses an RFC 3339 backfill flag
+func parseBackfillTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("backfill requires -%s", name)
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: %w", name, err)
+	}
+	return t, nil
+}
+
+// validate checks the options and that the query can be split into chunks
+func (o backfillOptions) validate(query string) error {
+	if o.start.IsZero() || o.end.IsZero() {
+		return fmt.Errorf("backfill requires -start and -end")
+	}
+	if !o.end.After(o.start) {
+		return fmt.Errorf("backfill end %s must be after start %s", o.end.Format(time.RFC3339), o.start.Format(time.RFC3339))
+	}
+	if o.chunk <= 0 {
+		return fmt.Errorf("chunk must be positive, got %s", o.chunk)
+	}
+	if o.concurrency <= 0 {
+		return fmt.Errorf("concurrency must be positive, got %d", o.concurrency)
+	}
+	if !strings.Contains(query, startPlaceholder) || !strings.Contains(query, endPlaceholder) {
+		return fmt.Errorf("backfill requires the query to filter on %s and %s, e.g. WHERE time >= %s AND time < %s",
+			startPlaceholder, endPlaceholder, startPlaceholder, endPlaceholder)
+	}
+	return nil
+}
+
+// loadCheckpoint returns the time the backfill resumes from, the start of the
+// range if there is no checkpoint yet
+func (o backfillOptions) loadCheckpoint() (time.Time, error) {
+	if o.checkpoint == "" {
+		return o.start, nil
+	}
+
+	data, err := os.ReadFile(o.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return o.start, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	var cp backfillCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse checkpoint %s: %w", o.checkpoint, err)
+	}
+	if !cp.Start.Equal(o.start) || !cp.End.Equal(o.end) {
+		return time.Time{}, fmt.Errorf("checkpoint %s is for the range %s to %s", o.checkpoint, cp.Start.Format(time.RFC3339), cp.End.Format(time.RFC3339))
+	}
+	return cp.Completed, nil
+}
+
+// saveCheckpoint records that everything before completed has been written.
+// The file is replaced atomically so an interruption cannot corrupt it.
+func (o backfillOptions) saveCheckpoint(completed time.Time) error {
+	if o.checkpoint == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(backfillCheckpoint{Start: o.start, End: o.end, Completed: completed})
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	tmp := o.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, o.checkpoint); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return nil
+}
+
+// runBackfill queries the range in chunks, at most opts.concurrency at a time,
+// and writes the metrics of every chunk to the output in chronological order. Progress
+// is checkpointed after each chunk, so an interrupted backfill resumes from
+// the first chunk not yet written. Deduplication is not applied.
+func (i *InfluxDBInput) runBackfill(ctx context.Context, opts backfillOptions, output metricOutput) error {
+	if err := opts.validate(i.Query); err != nil {
+		return err
+	}
+	resume, err := opts.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+	if !resume.Before(opts.end) {
+		i.Log.Infof("Backfill of %s to %s already completed", opts.start.Format(time.RFC3339), opts.end.Format(time.RFC3339))
+		return nil
+	}
+	if resume.After(opts.start) {
+		i.Log.Infof("Resuming backfill from %s", resume.Format(time.RFC3339))
+	}
+
+	var chunks []time.Time
+	for start := resume; start.Before(opts.end); start = start.Add(opts.chunk) {
+		chunks = append(chunks, start)
+	}
+	chunkEnd := func(idx int) time.Time {
+		return minTime(chunks[idx].Add(opts.chunk), opts.end)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Chunks are queried concurrently but written in order. A slot is only
+	// freed once its chunk is written, which bounds the buffered results.
+	results := make([]chan backfillChunk, len(chunks))
+	for idx := range results {
+		results[idx] = make(chan backfillChunk, 1)
+	}
+	slots := make(chan struct{}, opts.concurrency)
+	go func() {
+		for idx := range chunks {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			go func() {
+				queryCtx, queryCancel := context.WithTimeout(ctx, i.timeout)
+				defer queryCancel()
+
+				metrics, err := i.querySQLAPI(queryCtx, i.renderWindow(chunks[idx], chunkEnd(idx)))
+				results[idx] <- backfillChunk{metrics: metrics, err: err}
+			}()
+		}
+	}()
+
+	var total int
+	for idx := range chunks {
+		var result backfillChunk
+		select {
+		case result = <-results[idx]:
+		case <-ctx.Done():
+			return fmt.Errorf("backfill interrupted at %s: %w", chunks[idx].Format(time.RFC3339), ctx.Err())
+		}
+		if result.err != nil {
+			return fmt.Errorf("failed to backfill %s to %s: %w", chunks[idx].Format(time.RFC3339), chunkEnd(idx).Format(time.RFC3339), result.err)
+		}
+
+		batch := make([]telegraf.Metric, 0, len(result.metrics))
+		for _, m := range result.metrics {
+			batch = append(batch, metric.New(m.Name, m.Tags, m.Fields, m.Time))
+		}
+		if err := output.Write(ctx, batch); err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+		if err := opts.saveCheckpoint(chunkEnd(idx)); err != nil {
+			return err
+		}
+		<-slots
+
+		total += len(batch)
+		i.Log.Infof("Backfilled %s to %s (%d/%d): %d metrics", chunks[idx].Format(time.RFC3339), chunkEnd(idx).Format(time.RFC3339), idx+1, len(chunks), len(batch))
+	}
+
+	i.Log.Infof("Backfill completed, %d metrics written", total)
+	return nil
+}
+
+// minTime returns the earlier of two times
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
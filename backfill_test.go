@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// backfillServer answers every chunk query with one row at the chunk start,
+// failing chunks starting at fail
+func backfillServer(t *testing.T, fail time.Time) (*httptest.Server, func() []string) {
+	window := regexp.MustCompile(`time >= '([^']+)' AND time < '([^']+)'`)
+
+	var mu sync.Mutex
+	var windows []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		match := window.FindStringSubmatch(request["q"])
+		if match == nil {
+			http.Error(w, "unexpected query "+request["q"], http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		windows = append(windows, match[1]+"/"+match[2])
+		mu.Unlock()
+
+		if match[1] == fail.Format(time.RFC3339Nano) {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, `[{"time": %q, "host": "server1", "value": 1}]`, match[1])
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), windows...)
+	}
+}
+
+// newBackfillPlugin creates a plugin initialized for backfilling
+func newBackfillPlugin(t *testing.T, url string) *InfluxDBInput {
+	plugin := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	plugin.URL = url
+	plugin.Query = "SELECT * FROM sensors WHERE time >= {{start}} AND time < {{end}}"
+	plugin.backfill = true
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	return plugin
+}
+
+// TestBackfill tests that the range is queried in chunks and written in order
+func TestBackfill(t *testing.T) {
+	server, windows := backfillServer(t, time.Time{})
+	plugin := newBackfillPlugin(t, server.URL)
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := backfillOptions{
+		start:       start,
+		end:         start.Add(5 * time.Hour),
+		chunk:       2 * time.Hour,
+		concurrency: 2,
+		checkpoint:  filepath.Join(t.TempDir(), "backfill.json"),
+	}
+
+	var buf bytes.Buffer
+	if err := plugin.runBackfill(context.Background(), opts, &streamOutput{w: &buf, serializer: &lineProtocolSerializer{}, log: &simpleLogger{}}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expected strings.Builder
+	for _, offset := range []int{0, 2, 4} {
+		fmt.Fprintf(&expected, "influxdb3_query_result,host=server1 value=1 %d\n", start.Add(time.Duration(offset)*time.Hour).UnixNano())
+	}
+	if buf.String() != expected.String() {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected.String(), buf.String())
+	}
+
+	// The last chunk is cut off at the end of the range
+	if got := windows(); len(got) != 3 || !slices.Contains(got, "2024-01-01T04:00:00Z/2024-01-01T05:00:00Z") {
+		t.Errorf("Expected 3 chunks ending at the end of the range, got %v", got)
+	}
+
+	if resume, err := opts.loadCheckpoint(); err != nil || !resume.Equal(opts.end) {
+		t.Errorf("Expected checkpoint at %v, got %v (%v)", opts.end, resume, err)
+	}
+}
+
+// TestBackfillResume tests that an interrupted backfill resumes after the
+// last written chunk
+func TestBackfillResume(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts := backfillOptions{
+		start:       start,
+		end:         start.Add(4 * time.Hour),
+		chunk:       time.Hour,
+		concurrency: 1,
+		checkpoint:  filepath.Join(t.TempDir(), "backfill.json"),
+	}
+
+	failing, _ := backfillServer(t, start.Add(2*time.Hour))
+	var buf bytes.Buffer
+	if err := newBackfillPlugin(t, failing.URL).runBackfill(context.Background(), opts, &streamOutput{w: &buf, serializer: &lineProtocolSerializer{}, log: &simpleLogger{}}); err == nil {
+		t.Fatal("Expected error for failed chunk")
+	}
+	if lines := strings.Count(buf.String(), "\n"); lines != 2 {
+		t.Errorf("Expected the 2 chunks before the failure to be written, got %d lines", lines)
+	}
+
+	server, windows := backfillServer(t, time.Time{})
+	buf.Reset()
+	if err := newBackfillPlugin(t, server.URL).runBackfill(context.Background(), opts, &streamOutput{w: &buf, serializer: &lineProtocolSerializer{}, log: &simpleLogger{}}); err != nil {
+		t.Fatalf("Unexpected error resuming: %v", err)
+	}
+	if got := windows(); len(got) != 2 || got[0] != "2024-01-01T02:00:00Z/2024-01-01T03:00:00Z" {
+		t.Errorf("Expected to resume at the failed chunk, got %v", got)
+	}
+
+	// A checkpoint for another range is rejected
+	opts.end = opts.end.Add(time.Hour)
+	if _, err := opts.loadCheckpoint(); err == nil {
+		t.Error("Expected error for checkpoint of another range")
+	}
+}
+
+// TestBackfillValidation tests invalid backfill options
+func TestBackfillValidation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query := "SELECT * FROM sensors WHERE time >= {{start}} AND time < {{end}}"
+	valid := backfillOptions{start: start, end: start.Add(time.Hour), chunk: time.Hour, concurrency: 1}
+	if err := valid.validate(query); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	invalid := []backfillOptions{
+		{end: start, chunk: time.Hour, concurrency: 1},
+		{start: start, end: start, chunk: time.Hour, concurrency: 1},
+		{start: start, end: start.Add(time.Hour), concurrency: 1},
+		{start: start, end: start.Add(time.Hour), chunk: time.Hour},
+	}
+	for idx, opts := range invalid {
+		if err := opts.validate(query); err == nil {
+			t.Errorf("Expected error for invalid options %d", idx)
+		}
+	}
+	if err := valid.validate("SELECT * FROM sensors WHERE time >= {{start}}"); err == nil {
+		t.Error("Expected error for query without end placeholder")
+	}
+
+	if _, err := parseBackfillTime("start", "yesterday"); err == nil {
+		t.Error("Expected error for invalid time")
+	}
+
+	// Placeholders are only allowed outside of backfills with a lookback
+	plugin := withConnection(&InfluxDBInput{Log: &simpleLogger{}})
+	plugin.Query = query
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for placeholders without late_data_lookback")
+	}
+}
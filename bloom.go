@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+)
+
+// bloomFilter is a fixed-size Bloom filter using double hashing
+type bloomFilter struct {
+	bits    []uint64
+	size    uint64 // Number of bits
+	hashes  uint64 // Number of hash functions
+	setBits uint64
+	items   int
+	start   time.Time
+}
+
+// newBloomFilter creates a filter sized for the expected number of items at
+// the given false-positive rate
+func newBloomFilter(expectedItems int, falsePositiveRate float64) *bloomFilter {
+	n := math.Max(float64(expectedItems), 1)
+	size := uint64(math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	size = (size + 63) / 64 * 64
+	hashes := uint64(math.Max(math.Round(float64(size)/n*math.Ln2), 1))
+
+	return &bloomFilter{
+		bits:   make([]uint64, size/64),
+		size:   size,
+		hashes: hashes,
+	}
+}
+
+// add inserts the item identified by the two base hashes
+func (f *bloomFilter) add(h1, h2 uint64) {
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			f.setBits++
+		}
+	}
+	f.items++
+}
+
+// contains reports whether the item identified by the two base hashes may
+// have been added
+func (f *bloomFilter) contains(h1, h2 uint64) bool {
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		if f.bits[bit/64]&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// reset clears the filter for reuse
+func (f *bloomFilter) reset(start time.Time) {
+	clear(f.bits)
+	f.setBits = 0
+	f.items = 0
+	f.start = start
+}
+
+// fillRatio returns the fraction of set bits
+func (f *bloomFilter) fillRatio() float64 {
+	return float64(f.setBits) / float64(f.size)
+}
+
+// bloomTracker is an approximate tracker for very high volumes built on
+// time-bucketed Bloom filters. New keys go into the current filter which is
+// rotated every span; lookups check all filters. With buckets filters of
+// span = window / buckets plus the current one, every key is remembered for
+// at least the tracking window. Keys may be reported as seen although they are
+// new (false positives) but never the other way around.
+type bloomTracker struct {
+	mu      sync.RWMutex
+	key     func(MetricData) string
+	span    time.Duration
+	filters []*bloomFilter // Index 0 is the current filter
+}
+
+// newBloomTracker creates a Bloom tracker for the given window, expecting at
+// most maxItems keys within the window. Each filter is sized so that the
+// combined false-positive rate of a lookup stays below falsePositiveRate.
+func newBloomTracker(key func(MetricData) string, window time.Duration, buckets, maxItems int, falsePositiveRate float64, now time.Time) *bloomTracker {
+	perFilter := (maxItems + buckets - 1) / buckets
+	filterRate := falsePositiveRate / float64(buckets+1)
+
+	t := &bloomTracker{
+		key:     key,
+		span:    window / time.Duration(buckets),
+		filters: make([]*bloomFilter, buckets+1),
+	}
+	for idx := range t.filters {
+		t.filters[idx] = newBloomFilter(perFilter, filterRate)
+		t.filters[idx].start = now.Add(-time.Duration(idx) * t.span)
+	}
+
+	return t
+}
+
+// baseHashes derives the two base hashes used for double hashing
+func (t *bloomTracker) baseHashes(m MetricData) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(t.key(m)))
+	sum := h.Sum(nil)
+
+	// Force the second hash to be odd so all bits can be reached
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:]) | 1
+}
+
+// isNew checks if a metric has (probably) not been seen before
+func (t *bloomTracker) isNew(m MetricData) bool {
+	h1, h2 := t.baseHashes(m)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, f := range t.filters {
+		if f.contains(h1, h2) {
+			return false
+		}
+	}
+	return true
+}
+
+// markSeen adds a metric to the current filter, rotating filters if the
+// current one has exceeded its span
+func (t *bloomTracker) markSeen(m MetricData, now time.Time) {
+	h1, h2 := t.baseHashes(m)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rotate(now)
+	t.filters[0].add(h1, h2)
+}
+
+// observe atomically checks and records a metric
+func (t *bloomTracker) observe(m MetricData, now time.Time) bool {
+	h1, h2 := t.baseHashes(m)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, f := range t.filters {
+		if f.contains(h1, h2) {
+			return false
+		}
+	}
+
+	t.rotate(now)
+	t.filters[0].add(h1, h2)
+	return true
+}
+
+// rotate starts a new current filter for every elapsed span, reusing the
+// oldest filter; the caller must hold the write lock
+func (t *bloomTracker) rotate(now time.Time) {
+	for rotations := 0; rotations < len(t.filters) && now.Sub(t.filters[0].start) >= t.span; rotations++ {
+		oldest := t.filters[len(t.filters)-1]
+		copy(t.filters[1:], t.filters[:len(t.filters)-1])
+		oldest.reset(t.filters[1].start.Add(t.span))
+		t.filters[0] = oldest
+	}
+
+	// Catch up after long idle periods
+	if now.Sub(t.filters[0].start) >= t.span {
+		t.filters[0].start = now
+	}
+}
+
+// removeOlderThan clears filters whose span ended before cutoff and returns
+// the number of keys they held
+func (t *bloomTracker) removeOlderThan(cutoff time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := 0
+	for _, f := range t.filters[1:] {
+		if f.items > 0 && f.start.Add(t.span).Before(cutoff) {
+			removed += f.items
+			f.reset(f.start)
+		}
+	}
+
+	return removed
+}
+
+// stats returns a summary of the tracker state
+func (t *bloomTracker) stats() trackerStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var stats trackerStats
+	notFalsePositive := 1.0
+	for _, f := range t.filters {
+		stats.Entries += f.items
+		stats.MemoryBytes += len(f.bits) * 8
+		notFalsePositive *= 1 - math.Pow(f.fillRatio(), float64(f.hashes))
+	}
+	stats.FillRatio = t.filters[0].fillRatio()
+	stats.FalsePositiveRate = 1 - notFalsePositive
+
+	return stats
+}
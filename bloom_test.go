@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testBloomMetric creates a metric with a unique name for Bloom tracker tests
+func testBloomMetric(i int) MetricData {
+	return MetricData{
+		Name:   fmt.Sprintf("metric_%d", i),
+		Fields: map[string]interface{}{"value": 1.0},
+		Time:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+// TestBloomTrackerFalsePositiveRate tests that seen metrics are never reported
+// as new and the false-positive rate stays close to the target
+func TestBloomTrackerFalsePositiveRate(t *testing.T) {
+	plugin := &InfluxDBInput{}
+	now := time.Now()
+	tracker := newBloomTracker(plugin.generateMetricKey, time.Hour, 4, 10000, 0.01, now)
+
+	for i := 0; i < 10000; i++ {
+		tracker.markSeen(testBloomMetric(i), now)
+	}
+
+	for i := 0; i < 10000; i++ {
+		if tracker.isNew(testBloomMetric(i)) {
+			t.Fatalf("Expected seen metric %d to not be new", i)
+		}
+	}
+
+	// All points went into a single filter sized for a quarter of them, so the
+	// rate is well above the target but must still be reported correctly
+	falsePositives := 0
+	for i := 10000; i < 20000; i++ {
+		if !tracker.isNew(testBloomMetric(i)) {
+			falsePositives++
+		}
+	}
+
+	stats := tracker.stats()
+	measured := float64(falsePositives) / 10000
+	if measured > 2*stats.FalsePositiveRate+0.001 {
+		t.Errorf("Measured false-positive rate %v far above estimate %v", measured, stats.FalsePositiveRate)
+	}
+	if stats.Entries != 10000 {
+		t.Errorf("Expected 10000 entries, got %d", stats.Entries)
+	}
+	if stats.FillRatio <= 0 || stats.FillRatio >= 1 {
+		t.Errorf("Expected fill ratio between 0 and 1, got %v", stats.FillRatio)
+	}
+}
+
+// TestBloomTrackerTargetRate tests the false-positive rate for evenly spread points
+func TestBloomTrackerTargetRate(t *testing.T) {
+	plugin := &InfluxDBInput{}
+	now := time.Now()
+	tracker := newBloomTracker(plugin.generateMetricKey, time.Hour, 4, 20000, 0.01, now)
+
+	// Spread the points over the tracking window
+	for i := 0; i < 20000; i++ {
+		tracker.markSeen(testBloomMetric(i), now.Add(time.Duration(i)*time.Hour/20000))
+	}
+
+	falsePositives := 0
+	for i := 20000; i < 70000; i++ {
+		if !tracker.isNew(testBloomMetric(i)) {
+			falsePositives++
+		}
+	}
+
+	if measured := float64(falsePositives) / 50000; measured > 0.015 {
+		t.Errorf("Expected false-positive rate around the 0.01 target, got %v", measured)
+	}
+}
+
+// TestBloomTrackerRotation tests that metrics are forgotten after the tracking window
+func TestBloomTrackerRotation(t *testing.T) {
+	plugin := &InfluxDBInput{}
+	now := time.Now()
+	tracker := newBloomTracker(plugin.generateMetricKey, time.Hour, 4, 1000, 0.001, now)
+
+	tracker.markSeen(testBloomMetric(1), now)
+
+	// Still remembered right at the end of the window
+	tracker.markSeen(testBloomMetric(2), now.Add(59*time.Minute))
+	if tracker.isNew(testBloomMetric(1)) {
+		t.Error("Expected metric to be remembered within the tracking window")
+	}
+
+	// Forgotten once the filter holding it has rotated out
+	tracker.markSeen(testBloomMetric(3), now.Add(76*time.Minute))
+	if !tracker.isNew(testBloomMetric(1)) {
+		t.Error("Expected metric to be forgotten after the tracking window")
+	}
+	if tracker.isNew(testBloomMetric(2)) {
+		t.Error("Expected recent metric to still be remembered")
+	}
+
+	// Idle periods longer than the window clear everything
+	tracker.markSeen(testBloomMetric(4), now.Add(10*time.Hour))
+	if !tracker.isNew(testBloomMetric(3)) {
+		t.Error("Expected metric to be forgotten after a long idle period")
+	}
+}
+
+// TestBloomValidation tests invalid Bloom settings
+func TestBloomValidation(t *testing.T) {
+	invalid := []*InfluxDBInput{
+		{DedupStrategy: "bloom", BloomFalsePositiveRate: 1.5},
+		{DedupStrategy: "bloom", BloomBuckets: -1},
+		{DedupStrategy: "bloom", DedupMode: "content", ReemitOnChange: true},
+	}
+
+	for idx, plugin := range invalid {
+		if err := withConnection(plugin).Init(); err == nil {
+			t.Errorf("Expected error for invalid config %d", idx)
+		}
+	}
+}
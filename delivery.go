@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// errDeliveryBacklog is returned when max_in_flight_groups groups await
+// delivery and no further metrics can be emitted
+var errDeliveryBacklog = errors.New("too many metric groups awaiting delivery")
+
+// deliveryTracker keeps the metric groups handed to Telegraf until their
+// delivery is confirmed. Points of in-flight groups are not emitted again,
+// and only delivered points are committed to the dedup tracker.
+type deliveryTracker struct {
+	acc  telegraf.TrackingAccumulator
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	groups  map[telegraf.TrackingID][]MetricData
+	pending map[string]int // In-flight count per point key
+	waiters map[telegraf.TrackingID]chan<- bool
+}
+
+// startDelivery enables delivery tracking on the accumulator passed to Start
+// and processes the delivery notifications in the background
+func (i *InfluxDBInput) startDelivery(acc telegraf.Accumulator) error {
+	tracking := acc.WithTracking(i.MaxInFlightGroups)
+	if tracking == nil {
+		return fmt.Errorf("delivery_guarantee is not supported by the accumulator")
+	}
+
+	d := &deliveryTracker{
+		acc:     tracking,
+		done:    make(chan struct{}),
+		groups:  make(map[telegraf.TrackingID][]MetricData),
+		pending: make(map[string]int),
+		waiters: make(map[telegraf.TrackingID]chan<- bool),
+	}
+	i.delivery = d
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			select {
+			case <-d.done:
+				return
+			case info := <-d.acc.Delivered():
+				i.onDelivery(info)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// stopDelivery stops processing delivery notifications. Groups still in
+// flight are forgotten and their points emitted again by the next gather.
+func (i *InfluxDBInput) stopDelivery() {
+	if i.delivery == nil {
+		return
+	}
+
+	close(i.delivery.done)
+	i.delivery.wg.Wait()
+	i.delivery = nil
+}
+
+// emitTracked adds the new metrics as one tracked group and returns the
+// number of emitted metrics. Metrics already seen or in flight are skipped.
+// If the maximum number of groups is in flight, nothing is emitted and
+// errDeliveryBacklog is returned. If delivered is given, the outcome of the
+// group's delivery is sent to it; it needs room for one value.
+func (i *InfluxDBInput) emitTracked(metrics []MetricData, delivered chan<- bool) (int, error) {
+	d := i.delivery
+	if d == nil {
+		return 0, fmt.Errorf("delivery_guarantee requires the plugin to be started")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.groups) >= i.MaxInFlightGroups {
+		return 0, errDeliveryBacklog
+	}
+
+	group := make([]MetricData, 0, len(metrics))
+	keys := make(map[string]struct{}, len(metrics))
+	for _, m := range metrics {
+		key := i.generateMetricKey(m)
+		if _, inGroup := keys[key]; inGroup || d.pending[key] > 0 || !i.tracker.isNew(m) {
+			continue
+		}
+		keys[key] = struct{}{}
+		group = append(group, m)
+	}
+	if len(group) == 0 {
+		return 0, nil
+	}
+
+	tracked := make([]telegraf.Metric, 0, len(group))
+	for _, m := range group {
+		tracked = append(tracked, metric.New(m.Name, m.Tags, m.Fields, m.Time))
+	}
+	id := d.acc.AddTrackingMetricGroup(tracked)
+
+	d.groups[id] = group
+	if delivered != nil {
+		d.waiters[id] = delivered
+	}
+	for key := range keys {
+		d.pending[key]++
+	}
+
+	return len(group), nil
+}
+
+// onDelivery commits the points of a delivered group to the dedup tracker.
+// Points of rejected groups are released so the next gather emits them again.
+func (i *InfluxDBInput) onDelivery(info telegraf.DeliveryInfo) {
+	d := i.delivery
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	group, ok := d.groups[info.ID()]
+	if !ok {
+		return
+	}
+	delete(d.groups, info.ID())
+	if delivered, ok := d.waiters[info.ID()]; ok {
+		delivered <- info.Delivered()
+		delete(d.waiters, info.ID())
+	}
+
+	now := time.Now()
+	for _, m := range group {
+		if info.Delivered() {
+			i.tracker.markSeen(m, now)
+		}
+
+		key := i.generateMetricKey(m)
+		if d.pending[key]--; d.pending[key] <= 0 {
+			delete(d.pending, key)
+		}
+	}
+
+	if !info.Delivered() {
+		i.Log.Warnf("Delivery of %d metrics failed, emitting them again with the next gather", len(group))
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newDeliveryPlugin creates a started plugin with delivery guarantee
+func newDeliveryPlugin(t *testing.T, url string, maxInFlight int) (*InfluxDBInput, *simpleAccumulator) {
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		DeliveryGuarantee:   true,
+		MaxInFlightGroups:   maxInFlight,
+		Log:                 &simpleLogger{},
+	})
+	plugin.URL = url
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Start(acc); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	t.Cleanup(plugin.Stop)
+	return plugin, acc
+}
+
+// waitDelivered waits until no metric groups are in flight
+func waitDelivered(t *testing.T, plugin *InfluxDBInput) {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		plugin.delivery.mu.Lock()
+		inFlight := len(plugin.delivery.groups)
+		plugin.delivery.mu.Unlock()
+		if inFlight == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for delivery")
+}
+
+// gatherCount gathers once and returns the number of emitted metrics
+func gatherCount(t *testing.T, plugin *InfluxDBInput, acc *simpleAccumulator) int {
+	acc.metrics = acc.metrics[:0]
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return len(acc.metrics)
+}
+
+// TestDeliveryGuarantee tests that metrics are only marked as seen once delivered
+func TestDeliveryGuarantee(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}, {"time": "2024-01-01T12:00:00Z", "host": "server2", "value": 2}]`))
+	}))
+	defer server.Close()
+
+	plugin, acc := newDeliveryPlugin(t, server.URL, 0)
+	if count := gatherCount(t, plugin, acc); count != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", count)
+	}
+	delivered := acc.metrics
+
+	// Metrics in flight are neither seen nor emitted again
+	if count := gatherCount(t, plugin, acc); count != 0 {
+		t.Errorf("Expected in-flight metrics to be skipped, got %d", count)
+	}
+	for _, m := range plugin.delivery.groups {
+		if !plugin.tracker.isNew(m[0]) {
+			t.Error("Expected in-flight metric not to be marked as seen")
+		}
+	}
+
+	if err := writeMetrics(io.Discard, &lineProtocolSerializer{}, delivered, plugin.Log); err != nil {
+		t.Fatalf("Unexpected error writing metrics: %v", err)
+	}
+	waitDelivered(t, plugin)
+
+	if count := gatherCount(t, plugin, acc); count != 0 {
+		t.Errorf("Expected delivered metrics to be suppressed, got %d", count)
+	}
+}
+
+// TestDeliveryRejected tests that metrics of failed deliveries are emitted again
+func TestDeliveryRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}]`))
+	}))
+	defer server.Close()
+
+	plugin, acc := newDeliveryPlugin(t, server.URL, 0)
+	if count := gatherCount(t, plugin, acc); count != 1 {
+		t.Fatalf("Expected 1 metric, got %d", count)
+	}
+	for _, m := range acc.metrics {
+		m.Reject()
+	}
+	waitDelivered(t, plugin)
+
+	if count := gatherCount(t, plugin, acc); count != 1 {
+		t.Errorf("Expected rejected metric to be emitted again, got %d", count)
+	}
+}
+
+// TestDeliveryMaxInFlight tests that gathers are deferred while the maximum
+// number of groups awaits delivery
+func TestDeliveryMaxInFlight(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"time": "2024-01-01T12:00:00Z", "host": "server%d", "value": 1}]`, requests.Add(1))
+	}))
+	defer server.Close()
+
+	plugin, acc := newDeliveryPlugin(t, server.URL, 1)
+	if count := gatherCount(t, plugin, acc); count != 1 {
+		t.Fatalf("Expected 1 metric, got %d", count)
+	}
+	first := acc.metrics
+
+	if count := gatherCount(t, plugin, acc); count != 0 {
+		t.Errorf("Expected gather to be deferred, got %d metrics", count)
+	}
+
+	for _, m := range first {
+		m.Accept()
+	}
+	waitDelivered(t, plugin)
+
+	if count := gatherCount(t, plugin, acc); count != 1 {
+		t.Errorf("Expected 1 metric after delivery, got %d", count)
+	}
+}
+
+// TestDeliveryValidation tests the delivery guarantee requirements
+func TestDeliveryValidation(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{DeliveryGuarantee: true, Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error without track_new_metrics_only")
+	}
+
+	plugin = withConnection(&InfluxDBInput{MaxInFlightGroups: -1, Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for negative max_in_flight_groups")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}]`))
+	}))
+	defer server.Close()
+
+	plugin = withConnection(&InfluxDBInput{QueryName: t.Name(), TrackNewMetricsOnly: true, DeliveryGuarantee: true, Log: &simpleLogger{}})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	if err := plugin.Gather(&simpleAccumulator{}); err == nil {
+		t.Error("Expected error when gathering without Start")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// queryAPIs lists the supported query APIs
+var queryAPIs = []string{"v3", "v2"}
+
+// fluxDialect requests annotated CSV with all annotations from /api/v2/query
+var fluxDialect = map[string]interface{}{
+	"annotations": []string{"datatype", "group", "default"},
+	"header":      true,
+	"delimiter":   ",",
+}
+
+// fluxSystemColumns are the columns of a Flux result that describe the
+// result itself rather than the data
+var fluxSystemColumns = []string{"result", "table", "_start", "_stop"}
+
+// fluxStringField marks a string value of a Flux result that is a field
+// rather than a tag
+type fluxStringField string
+
+// parseAnnotatedCSV parses the annotated CSV response of a Flux query into
+// rows like those of a SQL query. Every table starts with its annotations
+// and a header. Values are converted according to their #datatype, empty
+// values take the #default of their column and are omitted if there is none.
+// Like experimental.to(), string columns in the #group key become tags and
+// the other string columns, e.g. a string _value, become fields.
+// Unpivoted records of the same series and time, one per field, are merged
+// into a single row. An error table reported by InfluxDB is returned as an
+// error.
+func parseAnnotatedCSV(body []byte) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.FieldsPerRecord = -1
+
+	var rows []map[string]interface{}
+	merged := make(map[string]int) // Index of the row of a series and time
+	var header, datatypes, groups, defaults []string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse response: %w", err)
+		}
+
+		// Annotations start a new table
+		switch record[0] {
+		case "#datatype":
+			header, datatypes, groups, defaults = nil, record, nil, nil
+			continue
+		case "#default":
+			defaults = record
+			continue
+		case "#group":
+			groups = record
+			continue
+		}
+		if header == nil {
+			header = record
+			continue
+		}
+		if len(record) != len(header) {
+			return nil, fmt.Errorf("failed to parse response: %d columns in a table with %d", len(record), len(header))
+		}
+		if len(header) > 1 && header[1] == "error" {
+			return nil, fmt.Errorf("query failed: %s", record[1])
+		}
+
+		row := make(map[string]interface{}, len(header))
+		for idx := 1; idx < len(header); idx++ {
+			value := record[idx]
+			if value == "" && idx < len(defaults) {
+				value = defaults[idx]
+			}
+			if value == "" {
+				continue
+			}
+
+			datatype := "string"
+			if idx < len(datatypes) {
+				datatype = datatypes[idx]
+			}
+			converted, err := convertFluxValue(datatype, value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse column %q: %w", header[idx], err)
+			}
+			if isFluxStringField(header[idx], datatype, groups, idx) {
+				converted = fluxStringField(value)
+			}
+			row[header[idx]] = converted
+		}
+
+		row, field := fluxRow(row)
+		if field == "" {
+			rows = append(rows, row)
+			continue
+		}
+		key := fluxRowKey(row, field)
+		if idx, ok := merged[key]; ok {
+			if value, ok := row[field]; ok {
+				rows[idx][field] = value
+			}
+			continue
+		}
+		merged[key] = len(rows)
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// isFluxStringField returns whether a column holds string fields, i.e. it is
+// a string column outside the group key. _field and _measurement name the
+// field and the measurement and tables without a #group annotation keep
+// their string columns as tags.
+func isFluxStringField(column, datatype string, groups []string, idx int) bool {
+	if datatype != "string" || idx >= len(groups) || groups[idx] == "true" {
+		return false
+	}
+	return column != "_field" && column != "_measurement"
+}
+
+// convertFluxValue converts an annotated CSV value to its Go type.
+// Timestamps, durations and binary values are kept as strings.
+func convertFluxValue(datatype, value string) (interface{}, error) {
+	switch datatype {
+	case "double":
+		return strconv.ParseFloat(value, 64)
+	case "long":
+		return strconv.ParseInt(value, 10, 64)
+	case "unsignedLong":
+		return strconv.ParseUint(value, 10, 64)
+	case "boolean":
+		return strconv.ParseBool(value)
+	default:
+		return value, nil
+	}
+}
+
+// fluxRow maps a Flux record onto the columns of a SQL row: _time becomes
+// time and a _field/_value pair becomes a single field, whose name is
+// returned. The columns describing the result and the query range are
+// dropped.
+func fluxRow(row map[string]interface{}) (map[string]interface{}, string) {
+	for _, column := range fluxSystemColumns {
+		delete(row, column)
+	}
+
+	if t, ok := row["_time"]; ok {
+		row["time"] = t
+		delete(row, "_time")
+	}
+
+	field, ok := row["_field"].(string)
+	if ok {
+		if value, ok := row["_value"]; ok {
+			row[field] = value
+		}
+		delete(row, "_field")
+		delete(row, "_value")
+	}
+
+	return row, field
+}
+
+// fluxRowKey identifies the series and time of an unpivoted row by all of its
+// columns except the field
+func fluxRowKey(row map[string]interface{}, field string) string {
+	columns := make([]string, 0, len(row))
+	for column := range row {
+		if column != field {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+
+	var sb strings.Builder
+	for _, column := range columns {
+		fmt.Fprintf(&sb, "%s=%v|", column, row[column])
+	}
+	return sb.String()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fluxResponse holds two tables, the second one pivoted
+const fluxResponse = `#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#group,false,false,true,true,false,false,true,true,true
+#default,_result,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,host
+,,0,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:00Z,1.5,usage,cpu,server1
+,,0,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:10Z,,usage,cpu,server1
+
+#datatype,string,long,dateTime:RFC3339,string,long,boolean,string
+#group,false,false,false,true,false,false,true
+#default,_result,,,,,,server2
+,result,table,_time,_measurement,count,up,host
+,,1,2024-01-01T12:00:00.5Z,disk,42,true,
+`
+
+// TestParseAnnotatedCSV tests converting Flux tables into rows
+func TestParseAnnotatedCSV(t *testing.T) {
+	rows, err := parseAnnotatedCSV([]byte(fluxResponse))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+
+	expected := []map[string]interface{}{
+		{"time": "2024-01-01T12:00:00Z", "usage": 1.5, "_measurement": "cpu", "host": "server1"},
+		{"time": "2024-01-01T12:00:10Z", "_measurement": "cpu", "host": "server1"},
+		{"time": "2024-01-01T12:00:00.5Z", "_measurement": "disk", "count": int64(42), "up": true, "host": "server2"},
+	}
+	for idx, row := range rows {
+		if len(row) != len(expected[idx]) {
+			t.Errorf("Row %d: expected %v, got %v", idx, expected[idx], row)
+			continue
+		}
+		for key, value := range expected[idx] {
+			if row[key] != value {
+				t.Errorf("Row %d: expected %s = %v (%T), got %v (%T)", idx, key, value, value, row[key], row[key])
+			}
+		}
+	}
+
+	// Errors are reported as a table
+	response := "#datatype,string,string\n#group,true,true\n#default,,\n,error,reference\n,\"type error: missing bucket\",897\n"
+	if _, err := parseAnnotatedCSV([]byte(response)); err == nil || !strings.Contains(err.Error(), "missing bucket") {
+		t.Errorf("Expected query error, got %v", err)
+	}
+	if _, err := parseAnnotatedCSV([]byte("#datatype,string,long\n,result,table\n,,zero\n")); err == nil {
+		t.Error("Expected error for an invalid long")
+	}
+}
+
+// fluxUnpivotedResponse holds one table per field of the same series
+const fluxUnpivotedResponse = `#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#group,false,false,true,true,false,false,true,true,true
+#default,_result,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,host
+,,0,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:00Z,12.5,usage_user,cpu,server1
+,,0,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:10Z,13.5,usage_user,cpu,server1
+,,1,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:00Z,2.5,usage_system,cpu,server1
+,,2,2024-01-01T00:00:00Z,2024-01-02T00:00:00Z,2024-01-01T12:00:00Z,7.5,usage_user,cpu,server2
+`
+
+// TestFluxUnpivotedFields tests that the fields of a point, returned as one
+// record each, are merged into a single metric instead of being deduplicated
+func TestFluxUnpivotedFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fluxUnpivotedResponse))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		API:                 "v2",
+		Organization:        "plant",
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		DedupMode:           "key",
+		Log:                 &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	plugin.Query = `from(bucket: "telegraf") |> range(start: -1d)`
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(acc.metrics) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(acc.metrics))
+	}
+
+	m := acc.metrics[0]
+	if !m.Time().Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected time %s", m.Time())
+	}
+	user, _ := m.GetField("usage_user")
+	system, _ := m.GetField("usage_system")
+	if tag, _ := m.GetTag("host"); tag != "server1" || user != 12.5 || system != 2.5 {
+		t.Errorf("Expected both fields of server1 in one metric, got %v", m)
+	}
+	if len(acc.metrics[1].FieldList()) != 1 || len(acc.metrics[2].FieldList()) != 1 {
+		t.Errorf("Expected single fields for other points, got %v and %v", acc.metrics[1], acc.metrics[2])
+	}
+}
+
+// fluxStringResponse holds a string field next to a numeric one and a pivoted
+// table with a string column outside the group key
+const fluxStringResponse = `#datatype,string,long,dateTime:RFC3339,string,string,string,string
+#group,false,false,false,false,true,true,true
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,0,2024-01-01T12:00:00Z,ok,status,service,server1
+
+#datatype,string,long,dateTime:RFC3339,double,string,string,string
+#group,false,false,false,false,true,true,true
+#default,_result,,,,,,
+,result,table,_time,_value,_field,_measurement,host
+,,1,2024-01-01T12:00:00Z,0.5,load,service,server1
+
+#datatype,string,long,dateTime:RFC3339,string,string,string
+#group,false,false,false,true,true,false
+#default,_result,,,,,
+,result,table,_time,_measurement,host,state
+,,2,2024-01-01T12:00:00Z,disk,server2,mounted
+`
+
+// TestFluxStringFields tests that string values outside the group key become
+// fields rather than tags
+func TestFluxStringFields(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(fluxStringResponse))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		API:          "v2",
+		Organization: "plant",
+		QueryName:    t.Name(),
+		Log:          &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	plugin.Query = `from(bucket: "telegraf") |> range(start: -1d)`
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(acc.metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(acc.metrics))
+	}
+
+	m := acc.metrics[0]
+	status, _ := m.GetField("status")
+	load, _ := m.GetField("load")
+	if tag, _ := m.GetTag("host"); m.Name() != "service" || tag != "server1" || status != "ok" || load != 0.5 || len(m.TagList()) != 1 {
+		t.Errorf("Expected string field status and field load tagged with host, got %v", m)
+	}
+
+	m = acc.metrics[1]
+	state, _ := m.GetField("state")
+	if tag, _ := m.GetTag("host"); tag != "server2" || state != "mounted" || m.HasTag("state") {
+		t.Errorf("Expected pivoted string column as field, got %v", m)
+	}
+}
+
+// TestFluxQuery tests querying an InfluxDB 2.x through /api/v2/query
+func TestFluxQuery(t *testing.T) {
+	var request struct {
+		Query   string                 `json:"query"`
+		Type    string                 `json:"type"`
+		Dialect map[string]interface{} `json:"dialect"`
+	}
+	var path, org, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, org, authorization = r.URL.Path, r.URL.Query().Get("orgID"), r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&request)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Write([]byte(fluxResponse))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		API:                 "v2",
+		OrganizationID:      "0123456789abcdef",
+		Token:               "v2-token",
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		LateDataLookback:    "10m",
+		Log:                 &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	plugin.Query = `from(bucket: "telegraf") |> range(start: {{start}})`
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if path != "/api/v2/query" || org != "0123456789abcdef" || authorization != "Token v2-token" {
+		t.Errorf("Unexpected request to %s for org %q with %q", path, org, authorization)
+	}
+	if request.Type != "flux" || request.Dialect["header"] != true {
+		t.Errorf("Unexpected request body %+v", request)
+	}
+	start := strings.TrimSuffix(strings.TrimPrefix(request.Query, `from(bucket: "telegraf") |> range(start: `), ")")
+	if _, err := time.Parse(time.RFC3339Nano, start); err != nil {
+		t.Errorf("Expected a bare Flux time literal, got %q", request.Query)
+	}
+
+	// The row without a value has no fields and is dropped
+	if len(acc.metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(acc.metrics))
+	}
+	m := acc.metrics[0]
+	if value, _ := m.GetField("usage"); m.Name() != "cpu" || value != 1.5 || !m.Time().Equal(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected metric %v", m)
+	}
+	if tag, _ := acc.metrics[1].GetTag("host"); acc.metrics[1].Name() != "disk" || tag != "server2" {
+		t.Errorf("Unexpected metric %v", acc.metrics[1])
+	}
+}
+
+// TestFluxOptions tests invalid query API settings
+func TestFluxOptions(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{API: "v2", Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil || !strings.Contains(err.Error(), "organization") {
+		t.Errorf("Expected organization error, got %v", err)
+	}
+
+	plugin = withConnection(&InfluxDBInput{API: "v1", Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for unknown api")
+	}
+}
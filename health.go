@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthState records the outcome of the gathers for the health endpoints
+type healthState struct {
+	mu              sync.Mutex
+	interval        time.Duration // Gather interval, measured if not set explicitly
+	fixedInterval   bool
+	defaultInterval time.Duration // Assumed until the interval is known
+	lastGather      time.Time
+	lastSuccess     time.Time
+	lastError       string
+	lastErrorTime   time.Time
+}
+
+// setInterval sets a known gather interval instead of measuring it
+func (h *healthState) setInterval(interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.interval = interval
+	h.fixedInterval = true
+}
+
+// gatherStarted records the start of a gather and measures the interval
+// since the previous one
+func (h *healthState) gatherStarted(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.fixedInterval && !h.lastGather.IsZero() {
+		h.interval = now.Sub(h.lastGather)
+	}
+	h.lastGather = now
+}
+
+// gatherFinished records the result of a gather
+func (h *healthState) gatherFinished(now time.Time, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.lastError = err.Error()
+		h.lastErrorTime = now
+		return
+	}
+	h.lastSuccess = now
+}
+
+// ready reports whether the last successful query happened within the given
+// number of intervals. Until the interval is known the default interval is
+// used, without one a single successful query is enough.
+func (h *healthState) ready(now time.Time, intervals int) (bool, string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.lastSuccess.IsZero() {
+		return false, "no successful query yet"
+	}
+	interval := h.interval
+	if interval == 0 {
+		interval = h.defaultInterval
+	}
+	if interval > 0 {
+		maxAge := time.Duration(intervals) * interval
+		if age := now.Sub(h.lastSuccess); age > maxAge {
+			return false, fmt.Sprintf("last successful query %s ago, more than %d intervals of %s", age.Round(time.Second), intervals, interval)
+		}
+	}
+	return true, "ok"
+}
+
+// queryStatus is the status of a single query reported by /status
+type queryStatus struct {
+	Database      string        `json:"database"`
+	Ready         bool          `json:"ready"`
+	Interval      string        `json:"interval,omitempty"`
+	LastSuccess   *time.Time    `json:"last_success,omitempty"`
+	LastError     string        `json:"last_error,omitempty"`
+	LastErrorTime *time.Time    `json:"last_error_time,omitempty"`
+	Tracker       *trackerState `json:"tracker,omitempty"`
+}
+
+// trackerState is the dedup tracker summary reported by /status
+type trackerState struct {
+	Strategy    string `json:"strategy"`
+	Entries     int    `json:"entries"`
+	MemoryBytes int    `json:"memory_bytes"`
+	Evictions   uint64 `json:"evictions"`
+}
+
+// status returns the current status of the plugin's query
+func (i *InfluxDBInput) status(now time.Time) queryStatus {
+	ready, _ := i.health.ready(now, i.HealthReadyIntervals)
+
+	i.health.mu.Lock()
+	status := queryStatus{
+		Database:  i.Database,
+		Ready:     ready,
+		LastError: i.health.lastError,
+	}
+	if i.health.interval > 0 {
+		status.Interval = i.health.interval.String()
+	}
+	if !i.health.lastSuccess.IsZero() {
+		lastSuccess := i.health.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	if !i.health.lastErrorTime.IsZero() {
+		lastErrorTime := i.health.lastErrorTime
+		status.LastErrorTime = &lastErrorTime
+	}
+	i.health.mu.Unlock()
+
+	if i.tracker != nil {
+		stats := i.tracker.stats()
+		status.Tracker = &trackerState{
+			Strategy:    i.DedupStrategy,
+			Entries:     stats.Entries,
+			MemoryBytes: stats.MemoryBytes,
+			Evictions:   stats.Evictions,
+		}
+	}
+
+	return status
+}
+
+// healthHandler serves /healthz, /readyz and /status
+func (i *InfluxDBInput) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// The process is alive as long as it answers
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, reason := i.health.ready(time.Now(), i.HealthReadyIntervals)
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, reason)
+	})
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"queries": map[string]queryStatus{i.QueryName: i.status(time.Now())},
+		})
+	})
+
+	return mux
+}
+
+// startHealthServer starts serving the health endpoints on health_listen
+func (i *InfluxDBInput) startHealthServer() error {
+	listener, err := net.Listen("tcp", i.HealthListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on health_listen %q: %w", i.HealthListen, err)
+	}
+
+	server := &http.Server{
+		Handler:           i.healthHandler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	i.healthServer = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			i.Log.Errorf("Health server failed: %v", err)
+		}
+	}()
+	i.Log.Infof("Serving health endpoints on %s", listener.Addr())
+
+	return nil
+}
+
+// stopHealthServer shuts the health server down
+func (i *InfluxDBInput) stopHealthServer() {
+	if i.healthServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := i.healthServer.Shutdown(ctx); err != nil {
+		i.Log.Errorf("Failed to stop health server: %v", err)
+	}
+	i.healthServer = nil
+}
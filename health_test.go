@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestHealthReady tests the readiness decision
+func TestHealthReady(t *testing.T) {
+	var health healthState
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if ready, _ := health.ready(start, 3); ready {
+		t.Error("Expected not ready before the first successful query")
+	}
+
+	health.gatherStarted(start)
+	health.gatherFinished(start, nil)
+	if ready, _ := health.ready(start.Add(time.Hour), 3); !ready {
+		t.Error("Expected ready after a successful query without any interval")
+	}
+
+	// Until the interval is measured the default one applies, e.g. when the
+	// second gather never starts
+	health.defaultInterval = time.Minute
+	if ready, _ := health.ready(start.Add(3*time.Minute), 3); !ready {
+		t.Error("Expected ready within three default intervals of the last success")
+	}
+	if ready, _ := health.ready(start.Add(time.Hour), 3); ready {
+		t.Error("Expected not ready after three default intervals without success")
+	}
+
+	// The second gather measures the interval
+	health.gatherStarted(start.Add(10 * time.Second))
+	health.gatherFinished(start.Add(10*time.Second), errors.New("timeout"))
+	if ready, _ := health.ready(start.Add(30*time.Second), 3); !ready {
+		t.Error("Expected ready within three intervals of the last success")
+	}
+	if ready, _ := health.ready(start.Add(31*time.Second), 3); ready {
+		t.Error("Expected not ready after three intervals without success")
+	}
+
+	// A known interval is not overwritten by measurements
+	health.setInterval(time.Minute)
+	health.gatherStarted(start.Add(20 * time.Second))
+	if ready, _ := health.ready(start.Add(2*time.Minute), 3); !ready {
+		t.Error("Expected the configured interval to be used")
+	}
+}
+
+// TestHealthIntervalOption tests the health_interval setting
+func TestHealthIntervalOption(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	t.Cleanup(plugin.Stop)
+	if plugin.health.defaultInterval != time.Minute {
+		t.Errorf("Expected default health interval of 1m, got %s", plugin.health.defaultInterval)
+	}
+
+	for _, value := range []string{"soon", "0s"} {
+		plugin := withConnection(&InfluxDBInput{HealthInterval: value, Log: &simpleLogger{}})
+		if err := plugin.Init(); err == nil {
+			t.Errorf("Expected error for health_interval %q", value)
+		}
+	}
+}
+
+// TestHealthEndpoints tests the responses of the health endpoints
+func TestHealthEndpoints(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:           "plant",
+		TrackNewMetricsOnly: true,
+		Log:                 &simpleLogger{},
+	})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	handler := plugin.healthHandler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	if code := get("/healthz").Code; code != http.StatusOK {
+		t.Errorf("Expected /healthz to return 200, got %d", code)
+	}
+	if code := get("/readyz").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to return 503 before the first query, got %d", code)
+	}
+
+	now := time.Now()
+	plugin.health.gatherStarted(now)
+	plugin.health.gatherFinished(now, nil)
+	plugin.health.gatherFinished(now, errors.New("unexpected status code 500"))
+	if code := get("/readyz").Code; code != http.StatusOK {
+		t.Errorf("Expected /readyz to return 200 after a successful query, got %d", code)
+	}
+
+	var status struct {
+		Queries map[string]queryStatus `json:"queries"`
+	}
+	if err := json.NewDecoder(get("/status").Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	query, ok := status.Queries["plant"]
+	if !ok {
+		t.Fatalf("Expected status of query \"plant\", got %+v", status.Queries)
+	}
+	if !query.Ready || query.LastSuccess == nil || query.LastError != "unexpected status code 500" {
+		t.Errorf("Unexpected query status: %+v", query)
+	}
+	if query.Tracker == nil || query.Tracker.Strategy != "exact" {
+		t.Errorf("Expected exact tracker stats, got %+v", query.Tracker)
+	}
+}
+
+// TestHealthServer tests starting and stopping the health server
+func TestHealthServer(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{
+		HealthListen: "127.0.0.1:0",
+		Log:          &simpleLogger{},
+	})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	if err := plugin.Start(&simpleAccumulator{}); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	plugin.Stop()
+
+	plugin.HealthListen = "invalid address"
+	if err := plugin.Start(&simpleAccumulator{}); err == nil {
+		plugin.Stop()
+		t.Error("Expected error for invalid health_listen")
+	}
+}
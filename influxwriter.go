@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// writeAPIs lists the write endpoints supported by the built-in writer
+var writeAPIs = []string{"v3", "v2"}
+
+// influxWriterConfig holds the settings of the built-in writer
+type influxWriterConfig struct {
+	URL           string
+	API           string // "v3" for /api/v3/write_lp, "v2" for /api/v2/write
+	Token         string
+	Database      string // Database for v3, bucket for v2
+	Organization  string // Only used by v2
+	BatchSize     int
+	Gzip          bool
+	Retries       int
+	RetryInterval time.Duration // Doubled after every failed attempt
+	Timeout       time.Duration
+	Precision     time.Duration
+}
+
+// influxWriter writes metrics to the write endpoint of another InfluxDB in
+// batches of line protocol, retrying transient failures
+type influxWriter struct {
+	endpoint      string
+	authorization string
+	gzip          bool
+	batchSize     int
+	retries       int
+	retryInterval time.Duration
+	client        *http.Client
+	serializer    *lineProtocolSerializer
+	log           telegraf.Logger
+}
+
+// writeError is a failed write request. Transport errors, rate limiting and
+// server errors are retryable.
+type writeError struct {
+	status     int // Zero for transport errors
+	message    string
+	retryable  bool
+	retryAfter time.Duration
+}
+
+func (e *writeError) Error() string {
+	if e.status == 0 {
+		return e.message
+	}
+	return fmt.Sprintf("unexpected status code %d: %s", e.status, e.message)
+}
+
+// rejectedLinesError reports lines rejected by the server as unwritable,
+// e.g. due to a schema conflict. The other lines of a partial write have been
+// written, so the batch is not retried.
+type rejectedLinesError struct {
+	message string
+	lines   []string
+}
+
+func (e *rejectedLinesError) Error() string {
+	return e.message
+}
+
+// newInfluxWriter validates the settings and creates the writer
+func newInfluxWriter(cfg influxWriterConfig, log telegraf.Logger) (*influxWriter, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid write url %q: %w", cfg.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid write url %q, expected an http:// or https:// URL", cfg.URL)
+	}
+	if cfg.Database == "" {
+		return nil, fmt.Errorf("write database must be set")
+	}
+	if cfg.BatchSize <= 0 {
+		return nil, fmt.Errorf("write batch size must be positive, got %d", cfg.BatchSize)
+	}
+	if cfg.Retries < 0 {
+		return nil, fmt.Errorf("write retries must not be negative, got %d", cfg.Retries)
+	}
+	if cfg.Timeout <= 0 {
+		return nil, fmt.Errorf("write timeout must be positive, got %s", cfg.Timeout)
+	}
+
+	unit := timestampUnit(cfg.Precision)
+	params := url.Values{}
+	var path, scheme string
+	switch cfg.API {
+	case "", "v3":
+		path, scheme = "/api/v3/write_lp", "Bearer"
+		params.Set("db", cfg.Database)
+		params.Set("precision", map[time.Duration]string{
+			time.Second: "second", time.Millisecond: "millisecond", time.Microsecond: "microsecond", time.Nanosecond: "nanosecond",
+		}[unit])
+	case "v2":
+		path, scheme = "/api/v2/write", "Token"
+		params.Set("bucket", cfg.Database)
+		if cfg.Organization != "" {
+			params.Set("org", cfg.Organization)
+		}
+		params.Set("precision", map[time.Duration]string{
+			time.Second: "s", time.Millisecond: "ms", time.Microsecond: "us", time.Nanosecond: "ns",
+		}[unit])
+	default:
+		return nil, fmt.Errorf("invalid write api %q, expected one of %v", cfg.API, writeAPIs)
+	}
+
+	w := &influxWriter{
+		endpoint:      strings.TrimRight(cfg.URL, "/") + path + "?" + params.Encode(),
+		gzip:          cfg.Gzip,
+		batchSize:     cfg.BatchSize,
+		retries:       cfg.Retries,
+		retryInterval: cfg.RetryInterval,
+		client:        &http.Client{Timeout: cfg.Timeout},
+		serializer:    &lineProtocolSerializer{precision: cfg.Precision},
+		log:           log,
+	}
+	if cfg.Token != "" {
+		w.authorization = scheme + " " + cfg.Token
+	}
+	return w, nil
+}
+
+// Write sends the metrics in batches. Written metrics are accepted and
+// metrics that cannot be serialized are dropped. If a batch fails after all
+// retries, it and the remaining metrics are rejected and the *writeError is
+// returned.
+func (w *influxWriter) Write(ctx context.Context, metrics []telegraf.Metric) error {
+	// Dropped metrics must not be rejected later, so only serialized metrics
+	// are batched
+	pending := make([]telegraf.Metric, 0, len(metrics))
+	lines := make([][]byte, 0, len(metrics))
+	for _, m := range metrics {
+		line, err := w.serializer.Serialize(m)
+		if err != nil {
+			w.log.Warnf("Skipping metric: %v", err)
+			m.Drop()
+			continue
+		}
+		pending = append(pending, m)
+		lines = append(lines, line)
+	}
+
+	for start := 0; start < len(pending); start += w.batchSize {
+		end := min(start+w.batchSize, len(pending))
+
+		err := w.send(ctx, bytes.Join(lines[start:end], nil))
+		var rejected *rejectedLinesError
+		if errors.As(err, &rejected) {
+			w.log.Errorf("%s", rejected.message)
+			for _, line := range rejected.lines {
+				w.log.Errorf("  %s", line)
+			}
+			err = nil
+		}
+		if err != nil {
+			for _, m := range pending[start:] {
+				m.Reject()
+			}
+			return err
+		}
+		for _, m := range pending[start:end] {
+			m.Accept()
+		}
+	}
+
+	return nil
+}
+
+// send posts a batch, retrying retryable failures with exponential backoff
+func (w *influxWriter) send(ctx context.Context, body []byte) error {
+	if w.gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return fmt.Errorf("failed to compress metrics: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress metrics: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	delay := w.retryInterval
+	for attempt := 0; ; attempt++ {
+		err := w.post(ctx, body)
+		var werr *writeError
+		if !errors.As(err, &werr) || !werr.retryable || attempt >= w.retries {
+			return err
+		}
+
+		wait := delay
+		if werr.retryAfter > 0 {
+			wait = werr.retryAfter
+		}
+		w.log.Warnf("Write failed: %v, retrying in %s (%d/%d)", err, wait, attempt+1, w.retries)
+		select {
+		case <-ctx.Done():
+			return &writeError{message: ctx.Err().Error()}
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// post sends a single write request
+func (w *influxWriter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if w.authorization != "" {
+		req.Header.Set("Authorization", w.authorization)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &writeError{message: err.Error(), retryable: ctx.Err() == nil}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+
+	message, lines := parseWriteError(resp.Body)
+	switch {
+	case (resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnprocessableEntity) && len(lines) > 0:
+		// Only a response listing the rejected lines is a partial write,
+		// otherwise nothing may have been written
+		return &rejectedLinesError{
+			message: fmt.Sprintf("Write partially rejected (status code %d): %s", resp.StatusCode, message),
+			lines:   lines,
+		}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &writeError{
+			status:     resp.StatusCode,
+			message:    message,
+			retryable:  true,
+			retryAfter: time.Duration(retryAfter) * time.Second,
+		}
+	default:
+		return &writeError{status: resp.StatusCode, message: message}
+	}
+}
+
+// parseWriteError extracts the message and the rejected lines from an
+// error response. InfluxDB 3 lists the rejected lines in "data", InfluxDB 2
+// describes them in "message".
+func parseWriteError(r io.Reader) (string, []string) {
+	body, _ := io.ReadAll(io.LimitReader(r, 1<<20))
+
+	var response struct {
+		Error   string          `json:"error"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return strings.TrimSpace(string(body)), nil
+	}
+
+	message := response.Error
+	if message == "" {
+		message = response.Message
+	}
+
+	var lineErrors []struct {
+		OriginalLine string `json:"original_line"`
+		LineNumber   int    `json:"line_number"`
+		ErrorMessage string `json:"error_message"`
+	}
+	if len(response.Data) > 0 && json.Unmarshal(response.Data, &lineErrors) == nil {
+		lines := make([]string, 0, len(lineErrors))
+		for _, e := range lineErrors {
+			lines = append(lines, fmt.Sprintf("line %d: %s: %s", e.LineNumber, e.ErrorMessage, e.OriginalLine))
+		}
+		return message, lines
+	}
+
+	return message, nil
+}
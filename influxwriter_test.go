@@ -0,0 +1,271 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// writeRequest is a write request received by the test server
+type writeRequest struct {
+	path          string
+	query         string
+	authorization string
+	body          string
+}
+
+// writeServer records write requests and answers them with the given
+// responses in order, the last one repeated
+func writeServer(t *testing.T, responses ...func(w http.ResponseWriter)) (*httptest.Server, func() []writeRequest) {
+	var mu sync.Mutex
+	var requests []writeRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("Invalid gzip body: %v", err)
+				return
+			}
+			body = zr
+		}
+		data, _ := io.ReadAll(body)
+
+		mu.Lock()
+		requests = append(requests, writeRequest{r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(data)})
+		respond := responses[min(len(requests), len(responses))-1]
+		mu.Unlock()
+
+		respond(w)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, func() []writeRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]writeRequest(nil), requests...)
+	}
+}
+
+// respondWith responds with a status code and body
+func respondWith(code int, body string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.WriteHeader(code)
+		io.WriteString(w, body)
+	}
+}
+
+// writerMetrics creates n metrics with increasing timestamps
+func writerMetrics(n int) []telegraf.Metric {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metrics := make([]telegraf.Metric, 0, n)
+	for idx := range n {
+		metrics = append(metrics, metric.New("cpu", map[string]string{"host": "server1"}, map[string]interface{}{"usage": 1.5}, start.Add(time.Duration(idx)*time.Second)))
+	}
+	return metrics
+}
+
+// newTestWriter creates a writer for the test server
+func newTestWriter(t *testing.T, url string, cfg influxWriterConfig) *influxWriter {
+	cfg.URL = url
+	if cfg.Database == "" {
+		cfg.Database = "mirror"
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 100
+	}
+	cfg.RetryInterval = time.Millisecond
+	cfg.Timeout = 5 * time.Second
+
+	w, err := newInfluxWriter(cfg, &simpleLogger{})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	return w
+}
+
+// TestInfluxWriterBatches tests batching, compression and the endpoints
+func TestInfluxWriterBatches(t *testing.T) {
+	server, requests := writeServer(t, respondWith(http.StatusNoContent, ""))
+
+	w := newTestWriter(t, server.URL, influxWriterConfig{Token: "secret", BatchSize: 2, Gzip: true, Precision: time.Second})
+	if err := w.Write(context.Background(), writerMetrics(5)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := requests()
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 batches, got %d", len(got))
+	}
+	if got[0].path != "/api/v3/write_lp" || got[0].query != "db=mirror&precision=second" || got[0].authorization != "Bearer secret" {
+		t.Errorf("Unexpected v3 request %+v", got[0])
+	}
+	if expected := "cpu,host=server1 usage=1.5 1704110400\ncpu,host=server1 usage=1.5 1704110401\n"; got[0].body != expected {
+		t.Errorf("Expected body:\n%s\ngot:\n%s", expected, got[0].body)
+	}
+	if lines := strings.Count(got[2].body, "\n"); lines != 1 {
+		t.Errorf("Expected 1 line in the last batch, got %d", lines)
+	}
+
+	server, requests = writeServer(t, respondWith(http.StatusNoContent, ""))
+	w = newTestWriter(t, server.URL, influxWriterConfig{API: "v2", Token: "secret", Organization: "plant"})
+	if err := w.Write(context.Background(), writerMetrics(1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := requests(); got[0].path != "/api/v2/write" || got[0].query != "bucket=mirror&org=plant&precision=ns" || got[0].authorization != "Token secret" {
+		t.Errorf("Unexpected v2 request %+v", got[0])
+	}
+}
+
+// TestInfluxWriterRetries tests that transient failures are retried
+func TestInfluxWriterRetries(t *testing.T) {
+	server, requests := writeServer(t,
+		respondWith(http.StatusServiceUnavailable, `{"error": "overloaded"}`),
+		respondWith(http.StatusTooManyRequests, ""),
+		respondWith(http.StatusNoContent, ""),
+	)
+
+	w := newTestWriter(t, server.URL, influxWriterConfig{Retries: 3})
+	if err := w.Write(context.Background(), writerMetrics(1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if count := len(requests()); count != 3 {
+		t.Errorf("Expected 3 attempts, got %d", count)
+	}
+
+	// Give up after the retries, rejecting the metrics
+	server, requests = writeServer(t, respondWith(http.StatusInternalServerError, "boom"))
+	w = newTestWriter(t, server.URL, influxWriterConfig{Retries: 2})
+
+	acc := &simpleAccumulator{}
+	tracking := acc.WithTracking(1)
+	tracking.AddTrackingMetricGroup(writerMetrics(1))
+	err := w.Write(context.Background(), acc.metrics)
+	var werr *writeError
+	if !errors.As(err, &werr) || werr.status != http.StatusInternalServerError {
+		t.Fatalf("Expected write error with status 500, got %v", err)
+	}
+	if count := len(requests()); count != 3 {
+		t.Errorf("Expected 3 attempts, got %d", count)
+	}
+	if info := <-tracking.Delivered(); info.Delivered() {
+		t.Error("Expected failed metrics to be rejected")
+	}
+
+	// Client errors are not retried
+	server, requests = writeServer(t, respondWith(http.StatusUnauthorized, `{"error": "invalid token"}`))
+	w = newTestWriter(t, server.URL, influxWriterConfig{Retries: 2})
+	if err := w.Write(context.Background(), writerMetrics(1)); err == nil || !strings.Contains(err.Error(), "invalid token") {
+		t.Errorf("Expected authorization error, got %v", err)
+	}
+	if count := len(requests()); count != 1 {
+		t.Errorf("Expected a single attempt, got %d", count)
+	}
+}
+
+// TestInfluxWriterSkipsInvalidMetrics tests that unserializable metrics are
+// dropped and not rejected again when a batch fails
+func TestInfluxWriterSkipsInvalidMetrics(t *testing.T) {
+	server, requests := writeServer(t, respondWith(http.StatusInternalServerError, "boom"))
+	w := newTestWriter(t, server.URL, influxWriterConfig{BatchSize: 2})
+
+	metrics := writerMetrics(3)
+	invalid := metric.New("bad", nil, map[string]interface{}{"value": math.NaN()}, time.Now())
+	acc := &simpleAccumulator{}
+	tracking := acc.WithTracking(1)
+	tracking.AddTrackingMetricGroup([]telegraf.Metric{metrics[0], invalid, metrics[1], metrics[2]})
+
+	var werr *writeError
+	if err := w.Write(context.Background(), acc.metrics); !errors.As(err, &werr) {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+	if info := <-tracking.Delivered(); info.Delivered() {
+		t.Error("Expected failed metrics to be rejected")
+	}
+	got := requests()
+	if len(got) != 1 || strings.Count(got[0].body, "\n") != 2 || strings.Contains(got[0].body, "bad") {
+		t.Errorf("Expected a single batch of 2 valid metrics, got %+v", got)
+	}
+}
+
+// TestInfluxWriterPartialWrite tests that rejected lines are reported without
+// failing the write
+func TestInfluxWriterPartialWrite(t *testing.T) {
+	response := `{"error": "partial write of line protocol occurred", "data": [{"original_line": "cpu,host=server1 usage=\"high\" 1704110401000000000", "line_number": 2, "error_message": "invalid column type for column 'usage'"}]}`
+	server, _ := writeServer(t, respondWith(http.StatusBadRequest, response))
+
+	var log strings.Builder
+	w := newTestWriter(t, server.URL, influxWriterConfig{Retries: 3})
+	w.log = &simpleLogger{output: &log}
+
+	acc := &simpleAccumulator{}
+	tracking := acc.WithTracking(1)
+	tracking.AddTrackingMetricGroup(writerMetrics(2))
+	if err := w.Write(context.Background(), acc.metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info := <-tracking.Delivered(); !info.Delivered() {
+		t.Error("Expected partially written metrics not to be retried")
+	}
+	if !strings.Contains(log.String(), "line 2: invalid column type for column 'usage'") {
+		t.Errorf("Expected rejected line in log, got %q", log.String())
+	}
+}
+
+// TestInfluxWriterBadRequest tests that a bad request not listing rejected
+// lines fails the batch without retrying it
+func TestInfluxWriterBadRequest(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusUnprocessableEntity} {
+		server, requests := writeServer(t, respondWith(status, `{"error": "database name is invalid"}`))
+		w := newTestWriter(t, server.URL, influxWriterConfig{Retries: 3})
+
+		acc := &simpleAccumulator{}
+		tracking := acc.WithTracking(1)
+		tracking.AddTrackingMetricGroup(writerMetrics(2))
+		err := w.Write(context.Background(), acc.metrics)
+		var werr *writeError
+		if !errors.As(err, &werr) || werr.status != status || werr.retryable {
+			t.Errorf("Expected non-retryable write error with status %d, got %v", status, err)
+		}
+		if count := len(requests()); count != 1 {
+			t.Errorf("Expected a single attempt for status %d, got %d", status, count)
+		}
+		if info := <-tracking.Delivered(); info.Delivered() {
+			t.Errorf("Expected the metrics to be rejected for status %d", status)
+		}
+	}
+}
+
+// TestInfluxWriterOptions tests invalid writer settings
+func TestInfluxWriterOptions(t *testing.T) {
+	valid := influxWriterConfig{URL: "http://localhost:8181", Database: "mirror", BatchSize: 1, Timeout: time.Second}
+	invalid := []func(cfg *influxWriterConfig){
+		func(cfg *influxWriterConfig) { cfg.URL = "localhost:8181" },
+		func(cfg *influxWriterConfig) { cfg.Database = "" },
+		func(cfg *influxWriterConfig) { cfg.API = "v1" },
+		func(cfg *influxWriterConfig) { cfg.BatchSize = 0 },
+		func(cfg *influxWriterConfig) { cfg.Retries = -1 },
+	}
+
+	if _, err := newInfluxWriter(valid, &simpleLogger{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for idx, modify := range invalid {
+		cfg := valid
+		modify(&cfg)
+		if _, err := newInfluxWriter(cfg, &simpleLogger{}); err == nil {
+			t.Errorf("Expected error for invalid config %d", idx)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+var (
+	// nameEscaper escapes measurement names
+	nameEscaper = strings.NewReplacer(
+		"\t", `\t`,
+		"\n", `\n`,
+		"\f", `\f`,
+		"\r", `\r`,
+		`,`, `\,`,
+		` `, `\ `,
+	)
+
+	// keyEscaper escapes tag keys, tag values and field keys
+	keyEscaper = strings.NewReplacer(
+		"\t", `\t`,
+		"\n", `\n`,
+		"\f", `\f`,
+		"\r", `\r`,
+		`,`, `\,`,
+		` `, `\ `,
+		`=`, `\=`,
+	)
+
+	// stringFieldEscaper escapes string field values, newlines are allowed
+	// within the quotes
+	stringFieldEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+	)
+)
+
+// lineProtocolSerializer formats metrics as InfluxDB line protocol. Tags and
+// fields are written in sorted order so the output is deterministic. Fields
+// that cannot be represented, such as NaN or infinite floats, are skipped.
+// Timestamps are written in nanoseconds unless a precision is set, in which
+// case the coarsest unit of s, ms, us or ns not exceeding it is used.
+type lineProtocolSerializer struct {
+	precision time.Duration
+}
+
+// Serialize formats a single metric as one line of line protocol
+func (s *lineProtocolSerializer) Serialize(m telegraf.Metric) ([]byte, error) {
+	if m.Name() == "" {
+		return nil, errors.New("empty measurement name")
+	}
+
+	var sb strings.Builder
+	sb.WriteString(nameEscaper.Replace(m.Name()))
+
+	// Tags are sorted by key, empty keys or values are not allowed
+	tags := append([]*telegraf.Tag(nil), m.TagList()...)
+	sort.Slice(tags, func(a, b int) bool { return tags[a].Key < tags[b].Key })
+	for _, tag := range tags {
+		if tag.Key == "" || tag.Value == "" {
+			continue
+		}
+		sb.WriteString(",")
+		sb.WriteString(keyEscaper.Replace(tag.Key))
+		sb.WriteString("=")
+		sb.WriteString(keyEscaper.Replace(tag.Value))
+	}
+
+	fields := append([]*telegraf.Field(nil), m.FieldList()...)
+	sort.Slice(fields, func(a, b int) bool { return fields[a].Key < fields[b].Key })
+	written := 0
+	for _, field := range fields {
+		value, ok := formatFieldValue(field.Value)
+		if !ok || field.Key == "" {
+			continue
+		}
+
+		if written == 0 {
+			sb.WriteString(" ")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(keyEscaper.Replace(field.Key))
+		sb.WriteString("=")
+		sb.WriteString(value)
+		written++
+	}
+	if written == 0 {
+		return nil, fmt.Errorf("metric %q has no valid fields", m.Name())
+	}
+
+	sb.WriteString(" ")
+	sb.WriteString(strconv.FormatInt(m.Time().UnixNano()/int64(timestampUnit(s.precision)), 10))
+	sb.WriteString("\n")
+
+	return []byte(sb.String()), nil
+}
+
+// SerializeBatch formats all metrics, skipping the ones that cannot be represented
+func (s *lineProtocolSerializer) SerializeBatch(metrics []telegraf.Metric) ([]byte, error) {
+	var out []byte
+	for _, m := range metrics {
+		line, err := s.Serialize(m)
+		if err != nil {
+			continue
+		}
+		out = append(out, line...)
+	}
+	return out, nil
+}
+
+// timestampUnit returns the line protocol timestamp unit for a precision
+func timestampUnit(precision time.Duration) time.Duration {
+	for _, unit := range []time.Duration{time.Second, time.Millisecond, time.Microsecond} {
+		if precision >= unit {
+			return unit
+		}
+	}
+	return time.Nanosecond
+}
+
+// formatFieldValue formats a field value, returning false for values that
+// cannot be represented in line protocol
+func formatFieldValue(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return `"` + stringFieldEscaper.Replace(v) + `"`, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int8:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int16:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int32:
+		return strconv.FormatInt(int64(v), 10) + "i", true
+	case int64:
+		return strconv.FormatInt(v, 10) + "i", true
+	case uint:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10) + "u", true
+	case uint64:
+		return strconv.FormatUint(v, 10) + "u", true
+	case float32:
+		return formatFloat(float64(v), 32)
+	case float64:
+		return formatFloat(v, 64)
+	default:
+		return "", false
+	}
+}
+
+// formatFloat formats a float with the shortest representation that
+// round-trips, switching to exponent notation for very large or small values
+func formatFloat(v float64, bitSize int) (string, bool) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", false
+	}
+
+	abs := math.Abs(v)
+	if abs != 0 && (abs < 1e-7 || abs >= 1e21) {
+		return strconv.FormatFloat(v, 'e', -1, bitSize), true
+	}
+	return strconv.FormatFloat(v, 'f', -1, bitSize), true
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// TestLineProtocolSerializer compares the serializer output with the golden
+// files in testdata/lineprotocol, run with -update to regenerate them
+func TestLineProtocolSerializer(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		metrics []telegraf.Metric
+	}{
+		{
+			name: "sorted",
+			metrics: []telegraf.Metric{
+				metric.New("cpu",
+					map[string]string{"host": "server1", "env": "prod", "dc": "eu-1"},
+					map[string]interface{}{"usage_user": 12.5, "usage_idle": 80.0, "usage_system": 7.5},
+					timestamp,
+				),
+			},
+		},
+		{
+			name: "escaping",
+			metrics: []telegraf.Metric{
+				metric.New("disk usage,total",
+					map[string]string{"path": "/mnt/my data", "label=x": "a,b=c", "multi": "line\nbreak"},
+					map[string]interface{}{"free space": 1.0, "note": `say "hi" \o/`, "text": "first\nsecond"},
+					timestamp,
+				),
+			},
+		},
+		{
+			name: "types",
+			metrics: []telegraf.Metric{
+				metric.New("types",
+					map[string]string{},
+					map[string]interface{}{
+						"int":     int64(-42),
+						"uint":    uint64(math.MaxUint64),
+						"bool":    true,
+						"string":  "value",
+						"float":   0.1,
+						"whole":   42.0,
+						"precise": 123456789.123456789,
+						"huge":    1e300,
+						"tiny":    -2.5e-12,
+					},
+					timestamp,
+				),
+			},
+		},
+		{
+			name: "invalid_fields",
+			metrics: []telegraf.Metric{
+				metric.New("partial",
+					map[string]string{"host": "server1", "empty": ""},
+					map[string]interface{}{"nan": math.NaN(), "inf": math.Inf(1), "ok": 1.5},
+					timestamp,
+				),
+				metric.New("dropped",
+					map[string]string{"host": "server1"},
+					map[string]interface{}{"nan": math.NaN()},
+					timestamp,
+				),
+			},
+		},
+	}
+
+	serializer := &lineProtocolSerializer{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := serializer.SerializeBatch(tt.metrics)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			golden := filepath.Join("testdata", "lineprotocol", tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, actual, 0o644); err != nil {
+					t.Fatalf("Failed to update golden file: %v", err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("Failed to read golden file: %v", err)
+			}
+
+			if string(actual) != string(expected) {
+				t.Errorf("Output does not match %s\nexpected:\n%s\nactual:\n%s", golden, expected, actual)
+			}
+
+			// The output must round-trip through Telegraf's line protocol parser
+			parser := &influx.Parser{}
+			if err := parser.Init(); err != nil {
+				t.Fatalf("Failed to initialize parser: %v", err)
+			}
+			parsed, err := parser.Parse(actual)
+			if err != nil {
+				t.Fatalf("Output is not valid line protocol: %v", err)
+			}
+			for idx, m := range parsed {
+				line, err := serializer.Serialize(m)
+				if err != nil {
+					t.Fatalf("Failed to serialize parsed metric: %v", err)
+				}
+				original, _ := serializer.Serialize(tt.metrics[idx])
+				if string(line) != string(original) {
+					t.Errorf("Round trip changed metric\nexpected: %s\nactual:   %s", original, line)
+				}
+			}
+		})
+	}
+}
+
+// TestLineProtocolSerializerErrors tests metrics that cannot be serialized
+func TestLineProtocolSerializerErrors(t *testing.T) {
+	serializer := &lineProtocolSerializer{}
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := serializer.Serialize(metric.New("", nil, map[string]interface{}{"value": 1.0}, timestamp)); err == nil {
+		t.Error("Expected error for empty measurement name")
+	}
+
+	if _, err := serializer.Serialize(metric.New("cpu", nil, map[string]interface{}{"value": math.Inf(-1)}, timestamp)); err == nil {
+		t.Error("Expected error for metric without valid fields")
+	}
+}
+
+// TestLineProtocolPrecision tests the timestamp unit for different precisions
+func TestLineProtocolPrecision(t *testing.T) {
+	m := metric.New("cpu", nil, map[string]interface{}{"value": 1.0}, time.Unix(1704110400, 0))
+
+	tests := []struct {
+		precision time.Duration
+		expected  string
+	}{
+		{0, "cpu value=1 1704110400000000000\n"},
+		{time.Microsecond, "cpu value=1 1704110400000000\n"},
+		{time.Millisecond, "cpu value=1 1704110400000\n"},
+		{time.Second, "cpu value=1 1704110400\n"},
+		{time.Minute, "cpu value=1 1704110400\n"},
+	}
+
+	for _, tt := range tests {
+		serializer := &lineProtocolSerializer{precision: tt.precision}
+		line, err := serializer.Serialize(m)
+		if err != nil {
+			t.Fatalf("Failed to serialize metric: %v", err)
+		}
+		if string(line) != tt.expected {
+			t.Errorf("Precision %v: expected %q, got %q", tt.precision, tt.expected, line)
+		}
+	}
+}
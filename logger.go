@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// logFormats lists the log formats supported by the standalone logger
+var logFormats = []string{"text", "json"}
+
+// simpleLogger is a basic logger implementation for standalone execution.
+// Messages below the level are discarded, the zero value logs at info level
+// in text format. Attributes are appended to every line.
+type simpleLogger struct {
+	level  telegraf.LogLevel
+	json   bool
+	output io.Writer // Defaults to stderr
+
+	mu         sync.Mutex
+	attributes []logAttribute
+}
+
+// logAttribute is a key-value pair added to every log line
+type logAttribute struct {
+	key   string
+	value interface{}
+}
+
+// newSimpleLogger creates a logger for the given level and format
+func newSimpleLogger(level, format string) (*simpleLogger, error) {
+	l := &simpleLogger{level: telegraf.LogLevelFromString(level)}
+	if l.level == telegraf.None {
+		return nil, fmt.Errorf("unknown log level %q, expected error, warn, info, debug or trace", level)
+	}
+
+	switch format {
+	case "", "text":
+	case "json":
+		l.json = true
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected one of %v", format, logFormats)
+	}
+
+	return l, nil
+}
+
+// AddAttribute adds a key-value pair to all subsequent log lines, replacing
+// an existing attribute with the same key
+func (l *simpleLogger) AddAttribute(key string, value interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for idx := range l.attributes {
+		if l.attributes[idx].key == key {
+			l.attributes[idx].value = value
+			return
+		}
+	}
+	l.attributes = append(l.attributes, logAttribute{key: key, value: value})
+}
+
+// Level returns the configured log level, info if unset
+func (l *simpleLogger) Level() telegraf.LogLevel {
+	if l.level == telegraf.None {
+		return telegraf.Info
+	}
+	return l.level
+}
+
+// print writes a message at the given level if the level is enabled
+func (l *simpleLogger) print(level telegraf.LogLevel, msg string) {
+	if !l.Level().Includes(level) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Lines are written directly rather than through the standard logger,
+	// which the execd shim redirects and prefixes with its own level
+	output := l.output
+	if output == nil {
+		output = os.Stderr
+	}
+
+	if !l.json {
+		var sb strings.Builder
+		if l.output == nil {
+			sb.WriteString(time.Now().Format("2006/01/02 15:04:05 "))
+		}
+		sb.WriteString(level.String())
+		sb.WriteString(": ")
+		sb.WriteString(msg)
+		for _, attr := range l.attributes {
+			fmt.Fprintf(&sb, " %s=%v", attr.key, attr.value)
+		}
+		sb.WriteString("\n")
+		io.WriteString(output, sb.String())
+		return
+	}
+
+	entry := make(map[string]interface{}, len(l.attributes)+3)
+	for _, attr := range l.attributes {
+		entry[attr.key] = attr.value
+	}
+	entry["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = strings.ToLower(level.String())
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": "error", "msg": fmt.Sprintf("failed to encode log entry: %v", err)})
+	}
+
+	output.Write(append(line, '\n'))
+}
+
+// Fatalf logs an error message and exits with a non-zero status
+func (l *simpleLogger) Fatalf(format string, args ...interface{}) {
+	l.print(telegraf.Error, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *simpleLogger) Errorf(format string, args ...interface{}) {
+	l.print(telegraf.Error, fmt.Sprintf(format, args...))
+}
+
+func (l *simpleLogger) Error(args ...interface{}) {
+	l.print(telegraf.Error, fmt.Sprint(args...))
+}
+
+func (l *simpleLogger) Debugf(format string, args ...interface{}) {
+	l.print(telegraf.Debug, fmt.Sprintf(format, args...))
+}
+
+func (l *simpleLogger) Debug(args ...interface{}) {
+	l.print(telegraf.Debug, fmt.Sprint(args...))
+}
+
+func (l *simpleLogger) Warnf(format string, args ...interface{}) {
+	l.print(telegraf.Warn, fmt.Sprintf(format, args...))
+}
+
+func (l *simpleLogger) Warn(args ...interface{}) {
+	l.print(telegraf.Warn, fmt.Sprint(args...))
+}
+
+func (l *simpleLogger) Infof(format string, args ...interface{}) {
+	l.print(telegraf.Info, fmt.Sprintf(format, args...))
+}
+
+func (l *simpleLogger) Info(args ...interface{}) {
+	l.print(telegraf.Info, fmt.Sprint(args...))
+}
+
+func (l *simpleLogger) Trace(args ...interface{}) {
+	l.print(telegraf.Trace, fmt.Sprint(args...))
+}
+
+func (l *simpleLogger) Tracef(format string, args ...interface{}) {
+	l.print(telegraf.Trace, fmt.Sprintf(format, args...))
+}
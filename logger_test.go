@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf"
+)
+
+// TestLoggerLevels tests that messages below the configured level are discarded
+func TestLoggerLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newSimpleLogger("warn", "text")
+	if err != nil {
+		t.Fatalf("Unexpected error creating logger: %v", err)
+	}
+	logger.output = &buf
+
+	logger.Errorf("query failed: %s", "timeout")
+	logger.Warn("slow query")
+	logger.Info("gathered")
+	logger.Debugf("tracking %d entries", 10)
+	logger.Trace("row")
+
+	expected := "ERROR: query failed: timeout\nWARN: slow query\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// The zero value logs at info level
+	if level := (&simpleLogger{}).Level(); level != telegraf.Info {
+		t.Errorf("Expected default level info, got %v", level)
+	}
+}
+
+// TestLoggerDefaultOutput tests that text lines go to stderr directly, not
+// through the standard logger the shim redirects
+func TestLoggerDefaultOutput(t *testing.T) {
+	var redirected bytes.Buffer
+	log.SetOutput(&redirected)
+	defer log.SetOutput(os.Stderr)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	(&simpleLogger{}).Errorf("query failed: %s", "timeout")
+	os.Stderr = stderr
+	w.Close()
+
+	line, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(line), " ERROR: query failed: timeout\n") {
+		t.Errorf("Expected error line on stderr, got %q", line)
+	}
+	if redirected.Len() != 0 {
+		t.Errorf("Expected nothing written to the standard logger, got %q", redirected.String())
+	}
+}
+
+// TestLoggerJSON tests JSON log lines with attributes
+func TestLoggerJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newSimpleLogger("debug", "json")
+	if err != nil {
+		t.Fatalf("Unexpected error creating logger: %v", err)
+	}
+	logger.output = &buf
+
+	logger.AddAttribute("query", "plant")
+	logger.AddAttribute("database", "control")
+	logger.AddAttribute("query", "sensors")
+	logger.Debugf("Processed %d metrics", 3)
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode log line %q: %v", buf.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"level":    "debug",
+		"msg":      "Processed 3 metrics",
+		"query":    "sensors",
+		"database": "control",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected %s = %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Error("Expected time in log line")
+	}
+}
+
+// TestLoggerPluginAttributes tests that Init adds the query attributes
+func TestLoggerPluginAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := &simpleLogger{output: &buf}
+
+	plugin := withConnection(&InfluxDBInput{QueryName: "plant", Log: logger})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	plugin.Log.Info("started")
+
+	expected := "INFO: started query=plant database=test endpoint=http://localhost:8181\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+// TestLoggerOptions tests that invalid logging options are rejected
+func TestLoggerOptions(t *testing.T) {
+	if _, err := newSimpleLogger("verbose", "text"); err == nil || !strings.Contains(err.Error(), "log level") {
+		t.Errorf("Expected log level error, got %v", err)
+	}
+	if _, err := newSimpleLogger("info", "logfmt"); err == nil || !strings.Contains(err.Error(), "log format") {
+		t.Errorf("Expected log format error, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholders replaced by the start and end of the query window when
+// late_data_lookback is set or when backfilling
+const (
+	startPlaceholder = "{{start}}"
+	endPlaceholder   = "{{end}}"
+)
+
+// lookbackState tracks the newest timestamp returned by the query and the
+// last deep re-scan, which determine the start of the next query window
+type lookbackState struct {
+	mu        sync.Mutex
+	watermark time.Time
+	deepScan  time.Time
+}
+
+// parseLookback validates the late data settings. The query window must stay
+// within what the dedup tracker remembers, otherwise re-queried points would
+// be forwarded again.
+func (i *InfluxDBInput) parseLookback() error {
+	var err error
+	parse := func(name, value string) (time.Duration, error) {
+		if value == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		if d <= 0 {
+			return 0, fmt.Errorf("%s must be positive, got %s", name, value)
+		}
+		return d, nil
+	}
+
+	if i.lateDataLookback, err = parse("late_data_lookback", i.LateDataLookback); err != nil {
+		return err
+	}
+	if i.deepRescanInterval, err = parse("deep_rescan_interval", i.DeepRescanInterval); err != nil {
+		return err
+	}
+	if i.deepRescanWindow, err = parse("deep_rescan_window", i.DeepRescanWindow); err != nil {
+		return err
+	}
+
+	if i.lateDataLookback == 0 {
+		if i.deepRescanInterval > 0 || i.deepRescanWindow > 0 {
+			return fmt.Errorf("deep_rescan_interval and deep_rescan_window require late_data_lookback")
+		}
+		// Backfills fill in the window themselves
+		if !i.backfill && (strings.Contains(i.Query, startPlaceholder) || strings.Contains(i.Query, endPlaceholder)) {
+			return fmt.Errorf("query uses %s or %s but late_data_lookback is not set", startPlaceholder, endPlaceholder)
+		}
+		return nil
+	}
+	if !strings.Contains(i.Query, startPlaceholder) {
+		return fmt.Errorf("late_data_lookback requires the query to filter on %s, e.g. WHERE time >= %s", startPlaceholder, startPlaceholder)
+	}
+	if (i.deepRescanInterval > 0) != (i.deepRescanWindow > 0) {
+		return fmt.Errorf("deep_rescan_interval and deep_rescan_window must be set together")
+	}
+	if !i.TrackNewMetricsOnly {
+		return fmt.Errorf("late_data_lookback requires track_new_metrics_only = true to suppress re-queried points")
+	}
+
+	window := max(i.lateDataLookback, i.deepRescanWindow)
+	if window > i.trackingWindow {
+		return fmt.Errorf("metric_tracking_window %s must cover the query window of %s", i.trackingWindow, window)
+	}
+	if i.DedupStrategy == "watermark" && window > i.allowedLateness {
+		return fmt.Errorf("allowed_lateness %s must cover the query window of %s, older points are dropped by the watermark", i.allowedLateness, window)
+	}
+
+	return nil
+}
+
+// queryWindow returns the start of the next query window and whether the
+// gather is a deep re-scan. Deep re-scans run first and then every
+// deep_rescan_interval, other gathers re-query late_data_lookback before the
+// newest timestamp returned so far.
+func (i *InfluxDBInput) queryWindow(now time.Time) (time.Time, bool) {
+	i.lookback.mu.Lock()
+	defer i.lookback.mu.Unlock()
+
+	if i.deepRescanInterval > 0 && (i.lookback.deepScan.IsZero() || now.Sub(i.lookback.deepScan) >= i.deepRescanInterval) {
+		return now.Add(-i.deepRescanWindow), true
+	}
+	if i.lookback.watermark.IsZero() {
+		return now.Add(-i.lateDataLookback), false
+	}
+	return i.lookback.watermark.Add(-i.lateDataLookback), false
+}
+
+// renderQuery returns the query for a gather's window, unchanged without a
+// lookback
+func (i *InfluxDBInput) renderQuery(start, end time.Time) string {
+	if i.lateDataLookback == 0 {
+		return i.Query
+	}
+	return i.renderWindow(start, end)
+}
+
+// renderWindow returns the query for the window [start, end). SQL takes
+// quoted timestamps, Flux bare time literals.
+func (i *InfluxDBInput) renderWindow(start, end time.Time) string {
+	format := func(t time.Time) string {
+		if i.API == "v2" {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+		return "'" + t.UTC().Format(time.RFC3339Nano) + "'"
+	}
+	return strings.NewReplacer(
+		startPlaceholder, format(start),
+		endPlaceholder, format(end),
+	).Replace(i.Query)
+}
+
+// advanceWatermark records the newest timestamp of a successful gather.
+// Timestamps in the future are capped at now so they cannot move the window
+// past data still to arrive.
+func (i *InfluxDBInput) advanceWatermark(metrics []MetricData, deep bool, now time.Time) {
+	i.lookback.mu.Lock()
+	defer i.lookback.mu.Unlock()
+
+	for _, m := range metrics {
+		if m.Time.After(i.lookback.watermark) {
+			i.lookback.watermark = m.Time
+		}
+	}
+	if i.lookback.watermark.After(now) {
+		i.lookback.watermark = now
+	}
+	if deep {
+		i.lookback.deepScan = now
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLateDataLookback tests the query window and that late points are
+// forwarded once
+func TestLateDataLookback(t *testing.T) {
+	newest := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	late := newest.Add(-5 * time.Minute)
+
+	var queries []string
+	rows := []string{fmt.Sprintf(`{"time": %q, "host": "server1", "value": 1}`, newest.Format(time.RFC3339))}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request map[string]string
+		json.NewDecoder(r.Body).Decode(&request)
+		queries = append(queries, request["q"])
+		w.Write([]byte("[" + strings.Join(rows, ",") + "]"))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:            t.Name(),
+		TrackNewMetricsOnly:  true,
+		MetricTrackingWindow: "48h",
+		LateDataLookback:     "10m",
+		DeepRescanInterval:   "1h",
+		DeepRescanWindow:     "24h",
+		Log:                  &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	plugin.Query = "SELECT * FROM sensors WHERE time >= {{start}}"
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	gather := func() int {
+		acc := &simpleAccumulator{}
+		if err := plugin.Gather(acc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return len(acc.metrics)
+	}
+
+	// The first gather is a deep re-scan
+	if count := gather(); count != 1 {
+		t.Errorf("Expected 1 metric, got %d", count)
+	}
+	start, err := time.Parse(time.RFC3339Nano, strings.Trim(strings.TrimPrefix(queries[0], "SELECT * FROM sensors WHERE time >= "), "'"))
+	if err != nil {
+		t.Fatalf("Failed to parse window start of %q: %v", queries[0], err)
+	}
+	if deep := time.Since(start); deep < 24*time.Hour || deep > 24*time.Hour+time.Minute {
+		t.Errorf("Expected deep re-scan over 24h, got %s", deep)
+	}
+
+	// Later gathers look back from the newest timestamp, forwarding the late point once
+	rows = append(rows, fmt.Sprintf(`{"time": %q, "host": "server1", "value": 2}`, late.Format(time.RFC3339)))
+	for range 2 {
+		gather()
+	}
+	expected := fmt.Sprintf("SELECT * FROM sensors WHERE time >= '%s'", newest.Add(-10*time.Minute).Format(time.RFC3339Nano))
+	if queries[1] != expected || queries[2] != expected {
+		t.Errorf("Expected query %q, got %q", expected, queries[1:])
+	}
+
+	rows = rows[:1]
+	plugin.lookback.deepScan = time.Now().Add(-2 * time.Hour)
+	if count := gather(); count != 0 {
+		t.Errorf("Expected re-queried points to be suppressed, got %d", count)
+	}
+	if queries[3] == expected {
+		t.Error("Expected a deep re-scan once the interval has passed")
+	}
+}
+
+// TestLateDataWatermark tests that the window start only moves forward and
+// ignores future timestamps
+func TestLateDataWatermark(t *testing.T) {
+	plugin := &InfluxDBInput{lateDataLookback: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	plugin.advanceWatermark([]MetricData{{Time: now.Add(-time.Hour)}}, false, now)
+	plugin.advanceWatermark([]MetricData{{Time: now.Add(-2 * time.Hour)}}, false, now)
+	if start, _ := plugin.queryWindow(now); !start.Equal(now.Add(-time.Hour - time.Minute)) {
+		t.Errorf("Expected window start %v, got %v", now.Add(-time.Hour-time.Minute), start)
+	}
+
+	plugin.advanceWatermark([]MetricData{{Time: now.Add(time.Hour)}}, false, now)
+	if start, _ := plugin.queryWindow(now); !start.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected future timestamps to be capped at now, got %v", start)
+	}
+}
+
+// TestLateDataValidation tests invalid late data settings
+func TestLateDataValidation(t *testing.T) {
+	invalid := []*InfluxDBInput{
+		{TrackNewMetricsOnly: true},
+		{LateDataLookback: "-10m", TrackNewMetricsOnly: true},
+		{LateDataLookback: "10m"},
+		{LateDataLookback: "10m", DeepRescanInterval: "1h", TrackNewMetricsOnly: true},
+		{LateDataLookback: "10m", DeepRescanInterval: "1h", DeepRescanWindow: "24h", TrackNewMetricsOnly: true},
+		{LateDataLookback: "10m", DedupStrategy: "watermark", TrackNewMetricsOnly: true},
+	}
+
+	for idx, plugin := range invalid {
+		plugin.Log = &simpleLogger{}
+		withConnection(plugin).Query = "SELECT * FROM sensors WHERE time >= {{start}}"
+		if err := plugin.Init(); err == nil {
+			t.Errorf("Expected error for invalid config %d", idx)
+		}
+	}
+
+	// The placeholder is required with a lookback
+	plugin := withConnection(&InfluxDBInput{LateDataLookback: "10m", TrackNewMetricsOnly: true, Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil || !strings.Contains(err.Error(), startPlaceholder) {
+		t.Errorf("Expected placeholder error, got %v", err)
+	}
+}
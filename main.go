@@ -3,21 +3,35 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/plugins/common/shim"
 	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const sampleConfig = `
@@ -27,14 +41,24 @@ const sampleConfig = `
   ## API Token for authentication
   token = ""
   
-  ## Organization (for InfluxDB v2/v3 compatibility)
+  ## Query API (default: "v3")
+  ##   v3 - SQL via /api/v3/query_sql of InfluxDB3
+  ##   v2 - Flux via /api/v2/query of InfluxDB 2.x and InfluxDB Cloud (TSM);
+  ##        the token is sent as "Token <token>"
+  # api = "v3"
+
+  ## Organization name or ID to query, one of them is required by api = "v2"
   organization = ""
+  # organization_id = ""
   
   ## Database/Bucket to query
+  ## With api = "v2" the bucket is selected by the Flux query, the database
+  ## only identifies the query in logs and self-monitoring
   database = "telegraf"
   
   ## Query to execute for fetching data
-  ## Use InfluxQL or SQL depending on your InfluxDB3 setup
+  ## SQL for api = "v3", Flux for api = "v2", e.g.
+  ## 'from(bucket: "telegraf") |> range(start: -5m) |> filter(fn: (r) => r._measurement == "cpu")'
   query = "SELECT * FROM metrics ORDER BY time DESC LIMIT 100"
   
   ## Polling interval (how often to check for updates)
@@ -46,20 +70,181 @@ const sampleConfig = `
   ## Only propagate new metrics (deduplication)
   ## When enabled, tracks seen metrics and only forwards new ones
   track_new_metrics_only = true
+
+  ## How seen metrics are tracked (default: "exact")
+  ##   exact     - remember every propagated point
+  ##   watermark - remember only the newest timestamp per series (name + tags)
+  ##               and drop points at or before it; suited for append-only data
+  ##   bloom     - approximate tracking with rotating Bloom filters for very
+  ##               high volumes; new points are dropped with a small probability
+  # dedup_strategy = "exact"
+
+  ## Watermark strategy only: how far behind the newest timestamp of a series
+  ## out-of-order points are still forwarded (once)
+  # allowed_lateness = "0s"
+
+  ## Bloom strategy only: target false-positive rate of a lookup, i.e. the
+  ## probability of dropping a new point (default: 0.001), and the number of
+  ## filters the tracking window is split into (default: 4). The filters are
+  ## sized for max_tracked_metrics points per tracking window. The fill level
+  ## is reported in the influxdb_input_bloom measurement each gather.
+  # bloom_false_positive_rate = 0.001
+  # bloom_buckets = 4
+
+  ## Number of independently locked shards of the exact and watermark
+  ## trackers (default: 16); max_tracked_metrics is split evenly across them,
+  ## using fewer shards if each would hold less than 1024 metrics
+  # tracker_shards = 16
+
+  ## Re-query a trailing window for points arriving late, e.g. from sensors
+  ## on flaky links (default: disabled). The query must filter on {{start}},
+  ## which is replaced by the newest timestamp returned so far minus the
+  ## lookback, e.g. "SELECT * FROM sensors WHERE time >= {{start}}". An
+  ## optional {{end}} is replaced by the time of the gather.
+  ## Deduplication suppresses the re-queried points, so metric_tracking_window
+  ## (and allowed_lateness for the watermark strategy) must cover the window.
+  # late_data_lookback = "10m"
+  ## Periodically query a much longer window to catch very late data; the
+  ## first gather is always a deep re-scan
+  # deep_rescan_interval = "1h"
+  # deep_rescan_window = "24h"
+
+  ## Timestamp precision of the emitted metrics, e.g. "1s" for a
+  ## second-precision bucket (default: full precision)
+  ## Timestamps are rounded before deduplication, so points that only differ
+  ## below the precision are forwarded once. Telegraf applies the same setting
+  ## to the plugin's accumulator.
+  # precision = "1s"
+
+  ## Name of the query, used as the "query" tag of the self-monitoring
+  ## metrics (default: "default")
+  # query_name = "default"
+
+  ## Emit the influxdb_input_internal measurement every gather with query
+  ## latency, row, dedup, tracker and HTTP error counters. When running
+  ## in-process, the same counters are reported by inputs.internal.
+  # internal_metrics = false
+
+  ## Run the query on its own schedule instead of Telegraf's collection
+  ## interval, e.g. to query more often than other inputs (default: disabled)
+  ## With an interval set, Telegraf's gathers do nothing and the plugin
+  ## pushes the metrics of each scheduled query.
+  # query_interval = "10s"
+  ## Random delay added to each scheduled query to spread the load
+  # query_jitter = "0s"
+  ## Run scheduled queries on multiples of the interval, e.g. :00, :10, :20
+  ## for an interval of 10s
+  # query_align = false
+
+  ## What to do when a scheduled run of query_interval starts while the
+  ## previous query is still running (default: "skip")
+  ##   skip            - skip the new run
+  ##   queue_one       - start the new run once the previous one finishes,
+  ##                     skipping any further runs meanwhile
+  ##   cancel_previous - cancel the running query and start the new one
+  ## Skipped cycles are logged and counted in cycles_skipped.
+  # overlap_policy = "skip"
+
+  ## Serve /healthz, /readyz and /status on this address, e.g. for
+  ## Kubernetes probes (default: disabled)
+  # health_listen = ":8089"
+
+  ## /readyz fails once the last successful query is older than this many
+  ## gather intervals (default: 3)
+  # health_ready_intervals = 3
+
+  ## Gather interval assumed by /readyz until it is measured between two
+  ## gathers, usually Telegraf's interval (default: 1m)
+  # health_interval = "1m"
+
+  ## Only mark metrics as seen once Telegraf confirms their delivery to the
+  ## outputs; metrics of failed deliveries are emitted again by the next
+  ## gather, so the query window must still include them (default: false)
+  # delivery_guarantee = false
+  ## Maximum number of gathers awaiting delivery; further gathers defer their
+  ## metrics until a delivery completes (default: 16)
+  # max_in_flight_groups = 16
+
+  ## Accept new writes pushed by a WAL flush trigger of the InfluxDB3
+  ## processing engine on this address instead of only polling with the
+  ## query (default: disabled). Batches are POSTed to /push as line protocol
+  ## or, with Content-Type application/json, as the trigger's table batches
+  ## ([{"table_name": "cpu", "rows": [{...}]}]), optionally gzip compressed,
+  ## and run through the same transforms and deduplication as query results.
+  ## The query may be left empty to rely on pushes alone.
+  # push_listen = ":8090"
+  ## Shared token the trigger sends as "Authorization: Bearer <token>"
+  # push_token = ""
+  ## With delivery_guarantee, a push is only acknowledged once Telegraf has
+  ## delivered its metrics; unconfirmed pushes fail after this timeout so
+  ## the trigger retries them (default: 30s)
+  # push_ack_timeout = "30s"
+
+  ## OpenTelemetry tracing of every gather with child spans for the HTTP
+  ## query, decoding and deduplication (default: "none")
+  ##   none      - tracing disabled
+  ##   otlp_grpc - OTLP over gRPC, endpoint defaults to localhost:4317
+  ##   otlp_http - OTLP over HTTP, endpoint defaults to localhost:4318
+  ## The standard OTEL_EXPORTER_OTLP_* environment variables are honored.
+  # tracing_exporter = "none"
+  # tracing_endpoint = "otel-collector:4317"
+  # tracing_insecure = false
+  ## Fraction of gathers to trace (default: 1.0)
+  # tracing_sample_ratio = 1.0
+  # tracing_service_name = "telegraf-influxdb-input"
   
-  ## Maximum number of metrics to track in memory (default: 10000)
-  ## Older entries are removed when limit is exceeded
+  ## Maximum number of metrics (or series for the watermark strategy) to
+  ## track in memory (default: 10000)
+  ## The least recently seen entries are evicted when the limit is exceeded
   max_tracked_metrics = 10000
   
   ## Time window for metric tracking (default: 1h)
-  ## Metrics older than this are removed from tracking
+  ## Metrics (or series) not updated within this window are removed from tracking
   metric_tracking_window = "1h"
+
+  ## What identifies an already seen metric (default: "key")
+  ##   key     - measurement name + tags + timestamp
+  ##   content - additionally includes a hash of the field values, so a
+  ##             corrected value for an existing point is forwarded again
+  # dedup_mode = "key"
+
+  ## Only with dedup_mode = "content": keep a single field hash per point and
+  ## re-emit the point every time its field values change
+  # reemit_on_change = false
+
+  ## How seen metrics are stored (default: "string")
+  ##   string - the full canonical key (name, timestamp and all tags)
+  ##   hash   - a 16 byte FNV-1a hash of the canonical key, which bounds the
+  ##            memory per entry regardless of the tag set size; the chance of
+  ##            any collision among n tracked keys is about n^2 / 2^129
+  # dedup_key_format = "string"
   
   ## Optional TLS Config
   # tls_ca = "/etc/telegraf/ca.pem"
   # tls_cert = "/etc/telegraf/cert.pem"
   # tls_key = "/etc/telegraf/key.pem"
   # insecure_skip_verify = false
+
+  ## Transform pipeline applied to every row before deduplication
+  ## Each rule is scoped to the tag/field columns matching one of the globs in
+  ## "columns" and applies its actions in this order: drop_if_match,
+  ## pattern/replacement, mapping, scale/offset, case, rename
+  # [[inputs.influxdb_input.transform]]
+  #   columns = ["value"]
+  #   ## Drop the whole row if the column value matches this regex
+  #   # drop_if_match = "^-999$"
+  #   ## Regex replacement on string values
+  #   # pattern = "^sensor-(.*)$"
+  #   # replacement = "${1}"
+  #   ## Map values to other values (tags mapped to non-strings become fields)
+  #   # mapping = { "ON" = 1, "OFF" = 0 }
+  #   ## Linear scaling of numeric values: value * scale + offset
+  #   scale = 0.1
+  #   offset = 0.0
+  #   ## Change the case of string values ("lower" or "upper")
+  #   # case = "lower"
+  #   ## New column name, requires a single column without wildcards
+  #   rename = "temperature_c"
 `
 
 // InfluxDBInput represents the input plugin
@@ -67,6 +252,8 @@ type InfluxDBInput struct {
 	URL                  string `toml:"url"`
 	Token                string `toml:"token"`
 	Organization         string `toml:"organization"`
+	OrganizationID       string `toml:"organization_id"`
+	API                  string `toml:"api"`
 	Database             string `toml:"database"`
 	Query                string `toml:"query"`
 	Timeout              string `toml:"timeout"`
@@ -77,13 +264,77 @@ type InfluxDBInput struct {
 	TrackNewMetricsOnly  bool   `toml:"track_new_metrics_only"`
 	MaxTrackedMetrics    int    `toml:"max_tracked_metrics"`
 	MetricTrackingWindow string `toml:"metric_tracking_window"`
-
-	client         *http.Client
-	timeout        time.Duration
-	trackingWindow time.Duration
-	seenMetrics    map[string]time.Time
-	seenMetricsMu  sync.RWMutex
-	Log            telegraf.Logger `toml:"-"`
+	DedupMode            string `toml:"dedup_mode"`
+	ReemitOnChange       bool   `toml:"reemit_on_change"`
+	DedupKeyFormat       string `toml:"dedup_key_format"`
+	DedupStrategy        string `toml:"dedup_strategy"`
+	AllowedLateness      string `toml:"allowed_lateness"`
+
+	BloomFalsePositiveRate float64 `toml:"bloom_false_positive_rate"`
+	BloomBuckets           int     `toml:"bloom_buckets"`
+	TrackerShards          int     `toml:"tracker_shards"`
+
+	LateDataLookback   string `toml:"late_data_lookback"`
+	DeepRescanInterval string `toml:"deep_rescan_interval"`
+	DeepRescanWindow   string `toml:"deep_rescan_window"`
+
+	Precision string `toml:"precision"`
+
+	QueryName       string `toml:"query_name"`
+	InternalMetrics bool   `toml:"internal_metrics"`
+
+	QueryInterval string `toml:"query_interval"`
+	QueryJitter   string `toml:"query_jitter"`
+	QueryAlign    bool   `toml:"query_align"`
+	OverlapPolicy string `toml:"overlap_policy"`
+
+	HealthListen         string `toml:"health_listen"`
+	HealthReadyIntervals int    `toml:"health_ready_intervals"`
+	HealthInterval       string `toml:"health_interval"`
+
+	DeliveryGuarantee bool `toml:"delivery_guarantee"`
+	MaxInFlightGroups int  `toml:"max_in_flight_groups"`
+
+	PushListen     string `toml:"push_listen"`
+	PushToken      string `toml:"push_token"`
+	PushAckTimeout string `toml:"push_ack_timeout"`
+
+	TracingExporter    string  `toml:"tracing_exporter"`
+	TracingEndpoint    string  `toml:"tracing_endpoint"`
+	TracingInsecure    bool    `toml:"tracing_insecure"`
+	TracingSampleRatio float64 `toml:"tracing_sample_ratio"`
+	TracingServiceName string  `toml:"tracing_service_name"`
+
+	Transforms []TransformRule `toml:"transform"`
+
+	client             *http.Client
+	timeout            time.Duration
+	trackingWindow     time.Duration
+	allowedLateness    time.Duration
+	lateDataLookback   time.Duration
+	deepRescanInterval time.Duration
+	deepRescanWindow   time.Duration
+	lookback           lookbackState
+	backfill           bool // Set by the backfill command before Init
+	precision          time.Duration
+	queryInterval      time.Duration
+	queryJitter        time.Duration
+	tracker            dedupTracker
+	stats              *internalStats
+	health             healthState
+	healthServer       *http.Server
+	delivery           *deliveryTracker
+	pushServer         *http.Server
+	pushParser         *influx.Parser
+	pushAckTimeout     time.Duration
+	tracer             trace.Tracer
+	tracerProvider     *sdktrace.TracerProvider
+	ctx                context.Context // Cancelled by Stop
+	cancel             context.CancelFunc
+	wg                 sync.WaitGroup
+	overlap            gatherGuard
+	reportedEvictions  uint64
+	Log                telegraf.Logger `toml:"-"`
 }
 
 // Description returns a short description of the plugin
@@ -98,35 +349,233 @@ func (i *InfluxDBInput) SampleConfig() string {
 
 // Init initializes the plugin
 func (i *InfluxDBInput) Init() error {
-	var err error
-	i.timeout, err = time.ParseDuration(i.Timeout)
+	// Validate connection settings
+	if i.URL == "" {
+		return fmt.Errorf("url must be set")
+	}
+	u, err := url.Parse(i.URL)
 	if err != nil {
-		i.timeout = 5 * time.Second
+		return fmt.Errorf("invalid url %q: %w", i.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid url %q, expected an http:// or https:// URL", i.URL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %q, missing host", i.URL)
+	}
+	if i.Database == "" {
+		return fmt.Errorf("database must be set")
+	}
+	switch i.API {
+	case "", "v3":
+		i.API = "v3"
+	case "v2":
+		if i.Organization == "" && i.OrganizationID == "" {
+			return fmt.Errorf("api = \"v2\" requires organization or organization_id")
+		}
+	default:
+		return fmt.Errorf("invalid api %q, expected one of %v", i.API, queryAPIs)
+	}
+	if strings.TrimSpace(i.Query) == "" && i.PushListen == "" {
+		return fmt.Errorf("query must be set")
+	}
+
+	if i.QueryName == "" {
+		i.QueryName = "default"
+	}
+
+	// Parse request timeout
+	i.timeout = 5 * time.Second
+	if i.Timeout != "" {
+		i.timeout, err = time.ParseDuration(i.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout: %w", err)
+		}
+		if i.timeout <= 0 {
+			return fmt.Errorf("timeout must be positive, got %s", i.Timeout)
+		}
 	}
 
 	// Parse tracking window duration
+	i.trackingWindow = 1 * time.Hour
 	if i.MetricTrackingWindow != "" {
 		i.trackingWindow, err = time.ParseDuration(i.MetricTrackingWindow)
 		if err != nil {
-			i.trackingWindow = 1 * time.Hour
+			return fmt.Errorf("invalid metric_tracking_window: %w", err)
+		}
+		if i.trackingWindow <= 0 {
+			return fmt.Errorf("metric_tracking_window must be positive, got %s", i.MetricTrackingWindow)
 		}
-	} else {
-		i.trackingWindow = 1 * time.Hour
 	}
 
 	// Set defaults for tracking configuration
 	if i.MaxTrackedMetrics == 0 {
 		i.MaxTrackedMetrics = 10000
 	}
+	if i.MaxTrackedMetrics < 0 {
+		return fmt.Errorf("max_tracked_metrics must be positive, got %d", i.MaxTrackedMetrics)
+	}
+	if i.TrackerShards == 0 {
+		i.TrackerShards = 16
+	}
+	if i.TrackerShards < 0 {
+		return fmt.Errorf("tracker_shards must be positive, got %d", i.TrackerShards)
+	}
 
-	// Initialize seen metrics map if tracking is enabled
-	if i.TrackNewMetricsOnly {
-		i.seenMetrics = make(map[string]time.Time)
+	// Validate deduplication mode
+	switch i.DedupMode {
+	case "":
+		i.DedupMode = "key"
+	case "key", "content":
+	default:
+		return fmt.Errorf("invalid dedup_mode %q, expected \"key\" or \"content\"", i.DedupMode)
+	}
+	if i.ReemitOnChange && i.DedupMode != "content" {
+		return fmt.Errorf("reemit_on_change requires dedup_mode = \"content\"")
+	}
+	switch i.DedupKeyFormat {
+	case "":
+		i.DedupKeyFormat = "string"
+	case "string", "hash":
+	default:
+		return fmt.Errorf("invalid dedup_key_format %q, expected \"string\" or \"hash\"", i.DedupKeyFormat)
+	}
+
+	// Parse allowed lateness for the watermark strategy
+	if i.AllowedLateness != "" {
+		i.allowedLateness, err = time.ParseDuration(i.AllowedLateness)
+		if err != nil {
+			return fmt.Errorf("invalid allowed_lateness: %w", err)
+		}
+	}
+
+	// Parse timestamp precision, applied before deduplication
+	if i.Precision != "" {
+		i.precision, err = time.ParseDuration(i.Precision)
+		if err != nil {
+			return fmt.Errorf("invalid precision: %w", err)
+		}
+		if i.precision < 0 {
+			return fmt.Errorf("precision must not be negative, got %s", i.Precision)
+		}
+	}
+
+	// Parse the query's own schedule
+	if err := i.parseSchedule(); err != nil {
+		return err
+	}
+	switch i.OverlapPolicy {
+	case "":
+		i.OverlapPolicy = "skip"
+	case "skip", "queue_one", "cancel_previous":
+	default:
+		return fmt.Errorf("invalid overlap_policy %q, expected one of %v", i.OverlapPolicy, overlapPolicies)
+	}
+
+	// Initialize seen metrics tracker if tracking is enabled
+	switch i.DedupStrategy {
+	case "", "exact":
+		i.DedupStrategy = "exact"
+		if i.TrackNewMetricsOnly {
+			i.tracker = newExactTracker(i.MaxTrackedMetrics, i.TrackerShards, i.generateMetricKey, i.ReemitOnChange)
+		}
+	case "watermark":
+		if i.DedupMode == "content" {
+			return fmt.Errorf("dedup_mode = \"content\" is not supported with dedup_strategy = \"watermark\"")
+		}
+		if i.TrackNewMetricsOnly {
+			i.tracker = newWatermarkTracker(i.MaxTrackedMetrics, i.TrackerShards, i.generateSeriesKey, i.allowedLateness)
+		}
+	case "bloom":
+		if i.ReemitOnChange {
+			return fmt.Errorf("reemit_on_change is not supported with dedup_strategy = \"bloom\"")
+		}
+		if i.BloomFalsePositiveRate == 0 {
+			i.BloomFalsePositiveRate = 0.001
+		}
+		if i.BloomFalsePositiveRate <= 0 || i.BloomFalsePositiveRate >= 1 {
+			return fmt.Errorf("bloom_false_positive_rate must be between 0 and 1, got %v", i.BloomFalsePositiveRate)
+		}
+		if i.BloomBuckets == 0 {
+			i.BloomBuckets = 4
+		}
+		if i.BloomBuckets < 0 {
+			return fmt.Errorf("bloom_buckets must be positive, got %d", i.BloomBuckets)
+		}
+		if i.TrackNewMetricsOnly {
+			i.tracker = newBloomTracker(i.generateMetricKey, i.trackingWindow, i.BloomBuckets, i.MaxTrackedMetrics, i.BloomFalsePositiveRate, time.Now())
+		}
+	default:
+		return fmt.Errorf("invalid dedup_strategy %q, expected \"exact\", \"watermark\" or \"bloom\"", i.DedupStrategy)
+	}
+
+	// Validate the late data window, which relies on the tracker
+	if err := i.parseLookback(); err != nil {
+		return err
+	}
+
+	// Compile transform rules
+	for idx := range i.Transforms {
+		i.Transforms[idx].log = i.Log
+		if err := i.Transforms[idx].init(); err != nil {
+			return fmt.Errorf("transform %d: %w", idx+1, err)
+		}
+	}
+
+	// Validate delivery tracking settings
+	if i.MaxInFlightGroups == 0 {
+		i.MaxInFlightGroups = 16
+	}
+	if i.MaxInFlightGroups < 0 {
+		return fmt.Errorf("max_in_flight_groups must be positive, got %d", i.MaxInFlightGroups)
+	}
+	if i.DeliveryGuarantee && !i.TrackNewMetricsOnly {
+		return fmt.Errorf("delivery_guarantee requires track_new_metrics_only = true")
+	}
+
+	// Validate the push listener settings
+	if err := i.parsePush(); err != nil {
+		return err
+	}
+
+	// Validate health endpoint settings
+	if i.HealthReadyIntervals == 0 {
+		i.HealthReadyIntervals = 3
+	}
+	if i.HealthReadyIntervals < 0 {
+		return fmt.Errorf("health_ready_intervals must be positive, got %d", i.HealthReadyIntervals)
+	}
+	i.health.defaultInterval = time.Minute
+	if i.HealthInterval != "" {
+		i.health.defaultInterval, err = time.ParseDuration(i.HealthInterval)
+		if err != nil {
+			return fmt.Errorf("invalid health_interval: %w", err)
+		}
+		if i.health.defaultInterval <= 0 {
+			return fmt.Errorf("health_interval must be positive, got %s", i.HealthInterval)
+		}
+	}
+
+	// Register self-monitoring counters
+	if i.stats != nil {
+		i.stats.unregister()
+	}
+	i.stats = newInternalStats(map[string]string{"query": i.QueryName, "database": i.Database})
+
+	// Identify the query in every log line
+	i.Log.AddAttribute("query", i.QueryName)
+	i.Log.AddAttribute("database", i.Database)
+	i.Log.AddAttribute("endpoint", i.URL)
+
+	// Setup tracing, a no-op unless an exporter is configured
+	if err := i.initTracing(); err != nil {
+		return err
 	}
 
 	// Setup TLS configuration
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: i.InsecureSkipVerify,
+	tlsConfig, err := i.tlsConfig()
+	if err != nil {
+		return err
 	}
 
 	// Create HTTP client
@@ -140,58 +589,262 @@ func (i *InfluxDBInput) Init() error {
 	return nil
 }
 
-// Gather collects metrics from InfluxDB
+// tlsConfig builds the client TLS configuration from the configured CA and
+// client certificate files
+func (i *InfluxDBInput) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: i.InsecureSkipVerify,
+	}
+
+	if i.TLSCA != "" {
+		pem, err := os.ReadFile(i.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls_ca %q", i.TLSCA)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if i.TLSCert != "" || i.TLSKey != "" {
+		if i.TLSCert == "" || i.TLSKey == "" {
+			return nil, fmt.Errorf("tls_cert and tls_key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(i.TLSCert, i.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls_cert and tls_key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Gather collects metrics from InfluxDB. With query_interval set the query
+// runs on its own schedule and Gather does nothing.
 func (i *InfluxDBInput) Gather(acc telegraf.Accumulator) error {
-	ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+	if i.queryInterval > 0 {
+		return nil
+	}
+
+	ctx := i.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return i.gather(ctx, acc)
+}
+
+// gather runs the query and adds the new metrics to acc. Cancelling ctx
+// aborts the HTTP request.
+func (i *InfluxDBInput) gather(ctx context.Context, acc telegraf.Accumulator) error {
+	// Only one gather runs at a time, overlapping ones follow overlap_policy
+	ctx, done, ok := i.beginGather(ctx)
+	if !ok {
+		return nil
+	}
+	defer done()
+
+	// Without a query the metrics are only pushed, gathers just keep the
+	// tracker and the self-monitoring up to date
+	if strings.TrimSpace(i.Query) == "" {
+		i.pushHousekeeping(acc)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, i.timeout)
 	defer cancel()
 
+	ctx, span := i.startSpan(ctx, "gather")
+	defer span.End()
+
 	// Try SQL query first (InfluxDB3 Core uses SQL)
-	metrics, err := i.querySQLAPI(ctx)
+	start := time.Now()
+	windowStart, deep := i.queryWindow(start)
+	if i.lateDataLookback > 0 {
+		i.Log.Debugf("Querying from %s (deep re-scan: %t)", windowStart.UTC().Format(time.RFC3339), deep)
+		span.SetAttributes(attribute.Bool("influxdb_input.deep_rescan", deep))
+	}
+	i.health.gatherStarted(start)
+	metrics, err := i.querySQLAPI(ctx, i.renderQuery(windowStart, start))
+	i.stats.queryTime.Set(time.Since(start).Nanoseconds())
+	i.health.gatherFinished(time.Now(), err)
 	if err != nil {
 		i.Log.Errorf("Failed to query InfluxDB3: %v", err)
+		i.reportInternalMetrics(acc)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
 
+	if i.lateDataLookback > 0 {
+		i.advanceWatermark(metrics, deep, start)
+	}
+
 	// Clean up old entries from seen metrics before processing new ones
 	if i.TrackNewMetricsOnly {
 		i.cleanupOldMetrics()
 	}
 
 	// Add metrics to accumulator (with deduplication if enabled)
+	newMetricsCount, err := i.emit(ctx, acc, metrics, nil)
+	if errors.Is(err, errDeliveryBacklog) {
+		i.Log.Warnf("%d metric groups awaiting delivery, deferring %d metrics to the next gather", i.MaxInFlightGroups, len(metrics))
+	} else if err != nil {
+		return err
+	}
+
+	if i.TrackNewMetricsOnly {
+		i.Log.Debugf("Processed %d metrics, propagated %d new metrics", len(metrics), newMetricsCount)
+		stats := i.tracker.stats()
+		i.Log.Debugf("Tracking %d entries using approximately %d bytes", stats.Entries, stats.MemoryBytes)
+
+		// Report the fill level so the filters can be sized
+		if i.DedupStrategy == "bloom" {
+			acc.AddFields("influxdb_input_bloom", map[string]interface{}{
+				"entries":                       stats.Entries,
+				"memory_bytes":                  stats.MemoryBytes,
+				"fill_ratio":                    stats.FillRatio,
+				"estimated_false_positive_rate": stats.FalsePositiveRate,
+			}, map[string]string{"database": i.Database}, time.Now())
+		}
+	}
+
+	i.reportInternalMetrics(acc)
+
+	return nil
+}
+
+// emit adds the metrics not seen before to acc and returns their number. With
+// delivery_guarantee they are added as one tracked group and the outcome of
+// its delivery is sent to delivered, if given.
+func (i *InfluxDBInput) emit(ctx context.Context, acc telegraf.Accumulator, metrics []MetricData, delivered chan<- bool) (int, error) {
+	_, span := i.startSpan(ctx, "dedup", attribute.String("influxdb_input.dedup_strategy", i.DedupStrategy))
+
+	// A watermark only moves forward, so the points of a batch are observed
+	// oldest first, e.g. for queries ordered by time DESC
+	if i.DedupStrategy == "watermark" {
+		metrics = append([]MetricData(nil), metrics...)
+		sort.SliceStable(metrics, func(a, b int) bool { return metrics[a].Time.Before(metrics[b].Time) })
+	}
+
 	newMetricsCount := 0
-	for _, m := range metrics {
-		if i.TrackNewMetricsOnly {
-			// Check if metric is new
-			if i.isNewMetric(m) {
-				acc.AddFields(m.Name, m.Fields, m.Tags, m.Time)
-				i.markMetricAsSeen(m)
-				newMetricsCount++
+	var err error
+	if i.DeliveryGuarantee {
+		// Metrics are only committed to the tracker once delivered
+		newMetricsCount, err = i.emitTracked(metrics, delivered)
+		if err != nil && !errors.Is(err, errDeliveryBacklog) {
+			endSpan(span, err)
+			return 0, err
+		}
+	} else {
+		now := time.Now()
+		for _, m := range metrics {
+			// Check and mark in one step so concurrent gathers cannot both
+			// consider the same metric new
+			if i.TrackNewMetricsOnly && !i.tracker.observe(m, now) {
+				continue
 			}
-		} else {
-			// No tracking - add all metrics
+
 			acc.AddFields(m.Name, m.Fields, m.Tags, m.Time)
 			newMetricsCount++
 		}
 	}
+	i.stats.metricsEmitted.Incr(int64(newMetricsCount))
+	i.stats.metricsSuppressed.Incr(int64(len(metrics) - newMetricsCount))
+	span.SetAttributes(
+		attribute.Int("influxdb_input.metrics_emitted", newMetricsCount),
+		attribute.Int("influxdb_input.metrics_suppressed", len(metrics)-newMetricsCount),
+	)
+	span.End()
+
+	return newMetricsCount, err
+}
 
-	if i.TrackNewMetricsOnly {
-		i.Log.Debugf("Processed %d metrics, propagated %d new metrics", len(metrics), newMetricsCount)
+// querySQLAPI queries the InfluxDB3 SQL API
+func (i *InfluxDBInput) querySQLAPI(ctx context.Context, query string) ([]MetricData, error) {
+	body, err := i.executeQuery(ctx, query)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	_, span := i.startSpan(ctx, "decode")
+	result, err := i.parseResponse(body)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+
+	// Convert to metrics
+	metrics, dropped, filtered := i.convertRows(result)
+	span.SetAttributes(
+		attribute.Int("influxdb_input.rows_returned", len(result)),
+		attribute.Int("influxdb_input.rows_dropped", dropped),
+		attribute.Int("influxdb_input.rows_filtered", filtered),
+	)
+	endSpan(span, nil)
+
+	return metrics, nil
 }
 
-// querySQLAPI queries the InfluxDB3 SQL API
-func (i *InfluxDBInput) querySQLAPI(ctx context.Context) ([]MetricData, error) {
-	// Build the SQL query URL
-	queryURL := fmt.Sprintf("%s/api/v3/query_sql", strings.TrimRight(i.URL, "/"))
+// convertRows converts result rows into metrics and applies the transforms,
+// returning the number of rows dropped for lack of fields and filtered out
+// by a transform
+func (i *InfluxDBInput) convertRows(rows []map[string]interface{}) ([]MetricData, int, int) {
+	i.stats.rowsReturned.Incr(int64(len(rows)))
+	metrics := make([]MetricData, 0, len(rows))
+	var dropped, filtered int
+	for _, row := range rows {
+		m := i.convertRowToMetric(row)
+		if m == nil {
+			i.stats.rowsDropped.Incr(1)
+			dropped++
+			continue
+		}
+		if !i.applyTransforms(m) {
+			i.stats.rowsFiltered.Incr(1)
+			filtered++
+			continue
+		}
+		metrics = append(metrics, *m)
+	}
+	return metrics, dropped, filtered
+}
 
-	// Create request body
+// executeQuery runs the query against the SQL API, or the Flux API for
+// api = "v2", and returns the raw response
+func (i *InfluxDBInput) executeQuery(ctx context.Context, query string) (body []byte, err error) {
+	// Build the query URL and body, SQL for v3 and Flux for v2
+	queryURL := fmt.Sprintf("%s/api/v3/query_sql", strings.TrimRight(i.URL, "/"))
 	requestBody := map[string]interface{}{
 		"db":     i.Database,
-		"q":      i.Query,
+		"q":      query,
 		"format": "json",
 	}
+	accept, authScheme := "application/json", "Bearer "
+	if i.API == "v2" {
+		params := url.Values{}
+		if i.OrganizationID != "" {
+			params.Set("orgID", i.OrganizationID)
+		} else {
+			params.Set("org", i.Organization)
+		}
+		queryURL = fmt.Sprintf("%s/api/v2/query?%s", strings.TrimRight(i.URL, "/"), params.Encode())
+		requestBody = map[string]interface{}{
+			"query":   query,
+			"type":    "flux",
+			"dialect": fluxDialect,
+		}
+		accept, authScheme = "application/csv", "Token "
+	}
+
+	ctx, span := i.startSpan(ctx, "http_query",
+		attribute.String("http.request.method", http.MethodPost),
+		attribute.String("url.full", queryURL),
+		attribute.String("db.query.text", query),
+	)
+	defer func() { endSpan(span, err) }()
 
 	bodyBytes, err := json.Marshal(requestBody)
 	if err != nil {
@@ -206,46 +859,52 @@ func (i *InfluxDBInput) querySQLAPI(ctx context.Context) ([]MetricData, error) {
 
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Accept", accept)
 	if i.Token != "" {
-		req.Header.Set("Authorization", "Bearer "+i.Token)
+		req.Header.Set("Authorization", authScheme+i.Token)
 	}
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
 
 	// Execute request
 	resp, err := i.client.Do(req)
 	if err != nil {
+		i.stats.httpErrors.Incr(1)
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(body))
+		i.stats.httpErrors.Incr(1)
+		message, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(message))
 	}
 
-	// Parse response
-	body, err := io.ReadAll(resp.Body)
+	// Read response
+	body, err = io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Parse JSON response into metrics
+	return body, nil
+}
+
+// parseResponse parses the rows of a query response of the configured API
+func (i *InfluxDBInput) parseResponse(body []byte) ([]map[string]interface{}, error) {
+	if i.API == "v2" {
+		return parseAnnotatedCSV(body)
+	}
+	return parseQueryResponse(body)
+}
+
+// parseQueryResponse parses the JSON rows of a query response
+func parseQueryResponse(body []byte) ([]map[string]interface{}, error) {
 	var result []map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
-
-	// Convert to metrics
-	metrics := make([]MetricData, 0, len(result))
-	for _, row := range result {
-		m := i.convertRowToMetric(row)
-		if m != nil {
-			metrics = append(metrics, *m)
-		}
-	}
-
-	return metrics, nil
+	return result, nil
 }
 
 // MetricData represents a metric with its metadata
@@ -278,6 +937,11 @@ func (i *InfluxDBInput) convertRowToMetric(row map[string]interface{}) *MetricDa
 		delete(row, "time")
 	}
 
+	// Round like Telegraf's accumulator so the dedup key matches the emitted time
+	if i.precision > 0 {
+		m.Time = m.Time.Round(i.precision)
+	}
+
 	// Extract measurement name if present
 	if name, ok := row["_measurement"]; ok {
 		if nameStr, ok := name.(string); ok {
@@ -292,6 +956,12 @@ func (i *InfluxDBInput) convertRowToMetric(row map[string]interface{}) *MetricDa
 	// - Numeric, boolean, and special field values are fields (measurements)
 	// - Fields starting with underscore (except _measurement) are special fields
 	for key, value := range row {
+		// String fields of Flux results stay fields
+		if strVal, ok := value.(fluxStringField); ok {
+			m.Fields[key] = string(strVal)
+			continue
+		}
+
 		// Skip if key starts with underscore (special fields like _field, _value)
 		// but still add them as fields to preserve data
 		if strings.HasPrefix(key, "_") {
@@ -328,105 +998,142 @@ func (i *InfluxDBInput) generateMetricKey(m MetricData) string {
 	sb.WriteString("|")
 
 	// Include sorted tags for consistency
-	tags := make([]string, 0, len(m.Tags))
-	for k, v := range m.Tags {
-		tags = append(tags, fmt.Sprintf("%s=%s", k, v))
-	}
-	// Sort tags to ensure consistent key generation
-	if len(tags) > 0 {
-		sort.Strings(tags)
-		sb.WriteString(strings.Join(tags, ","))
+	writeSortedTags(&sb, m.Tags)
+
+	// In content mode every distinct field set is tracked as its own entry,
+	// unless a single hash per point is kept to detect changes
+	if i.DedupMode == "content" && !i.ReemitOnChange {
+		sb.WriteString("|")
+		sb.WriteString(strconv.FormatUint(hashFields(m.Fields), 16))
 	}
 
-	return sb.String()
+	return i.formatKey(sb.String())
 }
 
-// isNewMetric checks if a metric has been seen before
-func (i *InfluxDBInput) isNewMetric(m MetricData) bool {
-	key := i.generateMetricKey(m)
+// generateSeriesKey creates a key identifying the series of a metric based on its name and tags
+func (i *InfluxDBInput) generateSeriesKey(m MetricData) string {
+	var sb strings.Builder
 
-	i.seenMetricsMu.RLock()
-	_, exists := i.seenMetrics[key]
-	i.seenMetricsMu.RUnlock()
+	sb.WriteString(m.Name)
+	sb.WriteString("|")
+	writeSortedTags(&sb, m.Tags)
 
-	return !exists
+	return i.formatKey(sb.String())
 }
 
-// markMetricAsSeen adds a metric to the seen metrics map
-func (i *InfluxDBInput) markMetricAsSeen(m MetricData) {
-	key := i.generateMetricKey(m)
-
-	i.seenMetricsMu.Lock()
-	defer i.seenMetricsMu.Unlock()
-
-	// Add metric with current timestamp
-	i.seenMetrics[key] = time.Now()
-
-	// Enforce max tracked metrics limit
-	if len(i.seenMetrics) > i.MaxTrackedMetrics {
-		i.evictOldestMetrics()
+// writeSortedTags writes the tags as sorted, comma separated key=value pairs
+func writeSortedTags(sb *strings.Builder, tags map[string]string) {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	// Sort tags to ensure consistent key generation
+	if len(pairs) > 0 {
+		sort.Strings(pairs)
+		sb.WriteString(strings.Join(pairs, ","))
 	}
 }
 
-// cleanupOldMetrics removes metrics older than the tracking window
-func (i *InfluxDBInput) cleanupOldMetrics() {
-	i.seenMetricsMu.Lock()
-	defer i.seenMetricsMu.Unlock()
-
-	cutoffTime := time.Now().Add(-i.trackingWindow)
-	removed := 0
-
-	for key, timestamp := range i.seenMetrics {
-		if timestamp.Before(cutoffTime) {
-			delete(i.seenMetrics, key)
-			removed++
-		}
+// formatKey converts a canonical key into the configured key format
+func (i *InfluxDBInput) formatKey(key string) string {
+	// Store a fixed-size 128-bit hash instead of the full canonical key
+	if i.DedupKeyFormat == "hash" {
+		h := fnv.New128a()
+		h.Write([]byte(key))
+		return string(h.Sum(nil))
 	}
 
-	if removed > 0 {
-		i.Log.Debugf("Cleaned up %d old metric entries from tracking", removed)
-	}
+	return key
 }
 
-// evictOldestMetrics removes the oldest 10% of metrics when max limit is reached
-func (i *InfluxDBInput) evictOldestMetrics() {
-	// Find the 10% oldest metrics and remove them
-	numToRemove := len(i.seenMetrics) / 10
-	if numToRemove == 0 {
-		numToRemove = 1
+// hashFields computes a 64-bit FNV-1a hash over the sorted field set
+func hashFields(fields map[string]interface{}) uint64 {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	// Collect entries with timestamps
-	type entry struct {
-		key  string
-		time time.Time
-	}
-	entries := make([]entry, 0, len(i.seenMetrics))
-	for k, t := range i.seenMetrics {
-		entries = append(entries, entry{key: k, time: t})
+	h := fnv.New64a()
+	for _, k := range keys {
+		// Include the type so that e.g. 1 and "1" hash differently
+		fmt.Fprintf(h, "%s=%T:%v\x00", k, fields[k], fields[k])
 	}
 
-	// Sort by time (oldest first)
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].time.Before(entries[j].time)
-	})
+	return h.Sum64()
+}
 
-	// Remove oldest entries
-	for j := 0; j < numToRemove && j < len(entries); j++ {
-		delete(i.seenMetrics, entries[j].key)
+// isNewMetric checks if a metric has been seen before
+func (i *InfluxDBInput) isNewMetric(m MetricData) bool {
+	return i.tracker.isNew(m)
+}
+
+// markMetricAsSeen adds a metric to the seen metrics tracker
+func (i *InfluxDBInput) markMetricAsSeen(m MetricData) {
+	i.tracker.markSeen(m, time.Now())
+}
+
+// cleanupOldMetrics removes metrics older than the tracking window and
+// reports evictions caused by the size limit since the last cleanup
+func (i *InfluxDBInput) cleanupOldMetrics() {
+	removed := i.tracker.removeOlderThan(time.Now().Add(-i.trackingWindow))
+	if removed > 0 {
+		i.Log.Debugf("Cleaned up %d old metric entries from tracking", removed)
 	}
 
-	i.Log.Debugf("Evicted %d oldest metrics from tracking (limit: %d)", numToRemove, i.MaxTrackedMetrics)
+	evicted := i.tracker.stats().Evictions
+	if evicted > i.reportedEvictions {
+		i.Log.Debugf("Evicted %d oldest metrics from tracking (limit: %d)", evicted-i.reportedEvictions, i.MaxTrackedMetrics)
+		i.reportedEvictions = evicted
+	}
 }
 
 // Start starts the plugin (for service inputs)
 func (i *InfluxDBInput) Start(acc telegraf.Accumulator) error {
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+
+	if i.DeliveryGuarantee {
+		if err := i.startDelivery(acc); err != nil {
+			return err
+		}
+	}
+	if i.HealthListen != "" {
+		if err := i.startHealthServer(); err != nil {
+			i.stopDelivery()
+			return err
+		}
+	}
+	if i.PushListen != "" {
+		if err := i.startPushServer(acc); err != nil {
+			i.stopHealthServer()
+			i.stopDelivery()
+			return err
+		}
+	}
+	if i.queryInterval > 0 {
+		i.startScheduler(acc)
+	}
 	return nil
 }
 
-// Stop stops the plugin
+// Stop stops the plugin, cancelling in-flight queries and waiting for the
+// scheduled queries and pushes to finish
 func (i *InfluxDBInput) Stop() {
-	// Cleanup if needed
+	if i.cancel != nil {
+		i.cancel()
+	}
+	i.wg.Wait()
+
+	i.stopHealthServer()
+	i.stopPushServer()
+	i.stopDelivery()
+	if i.stats != nil {
+		i.stats.unregister()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i.shutdownTracing(ctx)
 }
 
 func init() {
@@ -441,165 +1148,326 @@ func init() {
 }
 
 func main() {
+	// An optional subcommand precedes the flags
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
 	// Command line flags
 	configFile := flag.String("config", "", "Configuration file path")
-	flag.Parse()
-
-	// For external plugin, we need to use Telegraf's shim
-	// This allows the plugin to run as a standalone executable
+	format := flag.String("format", "influx", "Output format: influx, json, csv or prometheus")
+	precision := flag.String("precision", "", "Timestamp precision of emitted metrics, e.g. 1s or 1ms")
+	logLevel := flag.String("log-level", "info", "Log level: error, warn, info, debug or trace")
+	logFormat := flag.String("log-format", "text", "Log format: text or json")
+	interval := flag.Duration("interval", 0, "Gather repeatedly at this interval instead of once, e.g. 10s (default: query_interval of the config file)")
+	backfillStart := flag.String("start", "", "Backfill: start of the range (inclusive), RFC 3339, e.g. 2024-01-01T00:00:00Z")
+	backfillEnd := flag.String("end", "", "Backfill: end of the range (exclusive), RFC 3339")
+	chunk := flag.Duration("chunk", time.Hour, "Backfill: time range queried at once")
+	concurrency := flag.Int("concurrency", 4, "Backfill: number of chunks queried in parallel")
+	checkpoint := flag.String("checkpoint", "", "Backfill: file recording the progress to resume after an interruption")
+	writeURL := flag.String("write-url", "", "Write the metrics to this InfluxDB instead of stdout, e.g. https://cloud:8181")
+	writeAPI := flag.String("write-api", "v3", "Write endpoint: v3 (/api/v3/write_lp) or v2 (/api/v2/write)")
+	writeDatabase := flag.String("write-database", "", "Database (v3) or bucket (v2) to write to")
+	writeOrg := flag.String("write-org", "", "Organization to write to (v2 only)")
+	writeToken := flag.String("write-token", os.Getenv("INFLUXDB_WRITE_TOKEN"), "Token for writing (default: $INFLUXDB_WRITE_TOKEN)")
+	writeBatchSize := flag.Int("write-batch-size", 5000, "Maximum number of metrics per write request")
+	writeGzip := flag.Bool("write-gzip", true, "Compress write requests with gzip")
+	writeRetries := flag.Int("write-retries", 3, "Retries of a failed write request with exponential backoff")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "Timeout of a write request")
+	spoolDir := flag.String("spool-dir", "", "Spool the metrics in this directory while the destination is unreachable")
+	spoolMaxSize := flag.Int64("spool-max-size", 1<<30, "Spool: maximum size in bytes, the oldest metrics are dropped beyond it")
+	spoolMaxAge := flag.Duration("spool-max-age", 0, "Spool: drop metrics spooled longer than this, e.g. 72h (default: no limit)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [test|validate|backfill] [flags]\n\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Without a command, gathers once and writes the metrics to stdout.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  test\tRun the query once and report how every row is processed\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  validate\tCheck the config file given by -config without querying\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "  backfill\tQuery the range from -start to -end in chunks and write the metrics to stdout\n\n")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
 
-	if *configFile != "" {
-		fmt.Printf("Using config file: %s\n", *configFile)
+	// Initialize logger, all further output goes through it
+	logger, err := newSimpleLogger(*logLevel, *logFormat)
+	if err != nil {
+		(&simpleLogger{}).Fatalf("Invalid logging options: %v", err)
 	}
 
-	// Create plugin instance
-	plugin := &InfluxDBInput{
-		URL:                 os.Getenv("INFLUXDB_URL"),
-		Token:               os.Getenv("INFLUXDB_TOKEN"),
-		Database:            os.Getenv("INFLUXDB_DATABASE"),
-		Query:               os.Getenv("INFLUXDB_QUERY"),
-		TrackNewMetricsOnly: true, // Enable by default
+	switch command {
+	case "", "test", "validate", "backfill":
+	default:
+		logger.Fatalf("Unknown command %q, expected \"test\", \"validate\" or \"backfill\"", command)
 	}
 
-	// Set defaults if not provided
-	if plugin.URL == "" {
-		plugin.URL = "http://localhost:8181"
+	// Check the config file and exit non-zero if it is invalid
+	if command == "validate" {
+		if *configFile == "" {
+			logger.Fatalf("The validate command requires -config")
+		}
+		if err := validateConfigFile(*configFile, logger); err != nil {
+			logger.Fatalf("Invalid configuration: %v", err)
+		}
+		fmt.Printf("%s: configuration is valid\n", *configFile)
+		return
 	}
-	if plugin.Database == "" {
-		plugin.Database = "control"
+
+	// Create plugin instance, either from the config file or the environment
+	var plugin *InfluxDBInput
+	if *configFile != "" {
+		plugin, err = loadConfigFile(*configFile)
+		if err != nil {
+			logger.Fatalf("Failed to load config file: %v", err)
+		}
+	} else {
+		plugin = &InfluxDBInput{
+			URL:                 os.Getenv("INFLUXDB_URL"),
+			Token:               os.Getenv("INFLUXDB_TOKEN"),
+			Database:            os.Getenv("INFLUXDB_DATABASE"),
+			Query:               os.Getenv("INFLUXDB_QUERY"),
+			TrackNewMetricsOnly: true, // Enable by default
+		}
+
+		// Set defaults if not provided
+		if plugin.URL == "" {
+			plugin.URL = "http://localhost:8181"
+		}
+		if plugin.Database == "" {
+			plugin.Database = "control"
+		}
+		if plugin.Query == "" {
+			plugin.Query = "SELECT * FROM opcua ORDER BY time DESC LIMIT 100"
+		}
 	}
-	if plugin.Query == "" {
-		plugin.Query = "SELECT * FROM opcua ORDER BY time DESC LIMIT 100"
+	if *precision != "" {
+		plugin.Precision = *precision
 	}
 
-	// Initialize logger
-	plugin.Log = &simpleLogger{}
+	plugin.Log = logger
 
 	// Initialize the plugin
+	plugin.backfill = command == "backfill"
 	if err := plugin.Init(); err != nil {
-		log.Fatalf("Failed to initialize plugin: %v", err)
+		logger.Fatalf("Failed to initialize plugin: %v", err)
 	}
 
-	// Create accumulator
-	acc := &simpleAccumulator{}
+	// Report how the query result is processed instead of emitting metrics
+	if command == "test" {
+		err := plugin.runQueryTest(os.Stdout)
+		plugin.Stop()
+		if err != nil {
+			logger.Fatalf("Query test failed: %v", err)
+		}
+		return
+	}
 
-	// Gather metrics once (in real usage, Telegraf handles the polling)
-	if err := plugin.Gather(acc); err != nil {
-		log.Fatalf("Failed to gather metrics: %v", err)
+	serializer, err := newSerializer(*format, plugin.precision)
+	if err != nil {
+		logger.Fatalf("Invalid output format: %v", err)
 	}
 
-	// Output metrics in line protocol format
-	for _, m := range acc.metrics {
-		// Format as line protocol manually
-		fmt.Printf("%s", formatLineProtocol(m))
+	// Write to stdout or replicate into another InfluxDB
+	var output metricOutput = &streamOutput{w: os.Stdout, serializer: serializer, log: plugin.Log}
+	if *writeURL != "" {
+		if *format != "influx" {
+			logger.Fatalf("Invalid output format: -format %s cannot be used with -write-url", *format)
+		}
+		output, err = newInfluxWriter(influxWriterConfig{
+			URL:           *writeURL,
+			API:           *writeAPI,
+			Token:         *writeToken,
+			Database:      *writeDatabase,
+			Organization:  *writeOrg,
+			BatchSize:     *writeBatchSize,
+			Gzip:          *writeGzip,
+			Retries:       *writeRetries,
+			RetryInterval: time.Second,
+			Timeout:       *writeTimeout,
+			Precision:     plugin.precision,
+		}, plugin.Log)
+		if err != nil {
+			logger.Fatalf("Invalid write options: %v", err)
+		}
 	}
-}
 
-// formatLineProtocol formats a metric in InfluxDB line protocol format
-func formatLineProtocol(m telegraf.Metric) string {
-	var sb strings.Builder
+	// Keep the metrics on disk until the destination accepts them
+	if *spoolDir != "" {
+		output, err = newSpoolOutput(*spoolDir, *spoolMaxSize, *spoolMaxAge, output, plugin.Log)
+		if err != nil {
+			logger.Fatalf("Invalid spool options: %v", err)
+		}
+	}
 
-	// Write measurement name
-	sb.WriteString(m.Name())
+	// Create accumulator
+	acc := &simpleAccumulator{log: logger}
+	acc.SetPrecision(plugin.precision)
+
+	// Write a historical range, e.g. when migrating data
+	if command == "backfill" {
+		opts := backfillOptions{chunk: *chunk, concurrency: *concurrency, checkpoint: *checkpoint}
+		if opts.start, err = parseBackfillTime("start", *backfillStart); err != nil {
+			logger.Fatalf("Invalid backfill range: %v", err)
+		}
+		if opts.end, err = parseBackfillTime("end", *backfillEnd); err != nil {
+			logger.Fatalf("Invalid backfill range: %v", err)
+		}
 
-	// Write tags
-	tags := m.Tags()
-	if len(tags) > 0 {
-		for k, v := range tags {
-			sb.WriteString(",")
-			sb.WriteString(k)
-			sb.WriteString("=")
-			sb.WriteString(v)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err = plugin.runBackfill(ctx, opts, output)
+		stop()
+		plugin.Stop()
+		if err != nil {
+			logger.Fatalf("Backfill failed: %v", err)
 		}
+		return
 	}
 
-	// Write fields
-	sb.WriteString(" ")
-	fields := m.Fields()
-	first := true
-	for k, v := range fields {
-		if !first {
-			sb.WriteString(",")
-		}
-		first = false
-		sb.WriteString(k)
-		sb.WriteString("=")
-		switch val := v.(type) {
-		case string:
-			sb.WriteString(fmt.Sprintf("\"%s\"", val))
-		case int, int64, int32, int16, int8:
-			sb.WriteString(fmt.Sprintf("%di", val))
-		case float64, float32:
-			sb.WriteString(fmt.Sprintf("%f", val))
-		case bool:
-			sb.WriteString(fmt.Sprintf("%t", val))
-		default:
-			sb.WriteString(fmt.Sprintf("%v", val))
+	// The binary schedules the gathers itself, a query_interval from the
+	// config file is used unless overridden by -interval
+	if *interval == 0 {
+		*interval = plugin.queryInterval
+	}
+	plugin.queryInterval = 0
+
+	// Keep gathering until interrupted, e.g. as a long-running execd process
+	if *interval > 0 {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := runInterval(ctx, plugin, acc, output, *interval); err != nil {
+			logger.Fatalf("Failed to run plugin: %v", err)
 		}
+		return
 	}
 
-	// Write timestamp (in nanoseconds)
-	sb.WriteString(" ")
-	sb.WriteString(fmt.Sprintf("%d", m.Time().UnixNano()))
-	sb.WriteString("\n")
+	// Gather metrics once (in real usage, Telegraf handles the polling),
+	// stopping the plugin afterwards to flush pending spans
+	if err := plugin.Start(acc); err != nil {
+		logger.Fatalf("Failed to start plugin: %v", err)
+	}
+	if err := plugin.Gather(acc); err != nil {
+		plugin.Stop()
+		logger.Fatalf("Failed to gather metrics: %v", err)
+	}
 
-	return sb.String()
+	// Output metrics in the selected format
+	err = output.Write(context.Background(), acc.take())
+	plugin.Stop()
+	if err != nil {
+		logger.Fatalf("Failed to write metrics: %v", err)
+	}
 }
 
-// simpleLogger is a basic logger implementation for standalone execution
-type simpleLogger struct{}
+// runInterval starts the plugin and gathers every interval until ctx is
+// done, writing the metrics of each gather to the output. Failed gathers and
+// failed writes to InfluxDB are logged and retried at the next interval.
+func runInterval(ctx context.Context, plugin *InfluxDBInput, acc *simpleAccumulator, output metricOutput, interval time.Duration) error {
+	plugin.health.setInterval(interval)
+	if err := plugin.Start(acc); err != nil {
+		return err
+	}
+	defer plugin.Stop()
 
-func (l *simpleLogger) AddAttribute(key string, value interface{}) {
-	// Not implemented for simple logger
-}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-func (l *simpleLogger) Level() telegraf.LogLevel {
-	return telegraf.Info
-}
+	// Gathers may overlap according to overlap_policy, but the output is
+	// written by one of them at a time
+	var mu sync.Mutex
+	var err error
+	plugin.schedule(interval).run(ctx, func(ctx context.Context) {
+		// Failed queries are logged by Gather and show up in /readyz
+		_ = plugin.Gather(acc)
 
-func (l *simpleLogger) Errorf(format string, args ...interface{}) {
-	log.Printf("ERROR: "+format, args...)
-}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			return
+		}
+		var werr *writeError
+		if err = output.Write(ctx, acc.take()); errors.As(err, &werr) {
+			plugin.Log.Errorf("Failed to write metrics: %v", err)
+			err = nil
+		} else if err != nil {
+			err = fmt.Errorf("failed to write metrics: %w", err)
+			cancel()
+		}
+	}, plugin.skipMissedRuns)
 
-func (l *simpleLogger) Error(args ...interface{}) {
-	log.Print(append([]interface{}{"ERROR: "}, args...)...)
+	return err
 }
 
-func (l *simpleLogger) Debugf(format string, args ...interface{}) {
-	log.Printf("DEBUG: "+format, args...)
-}
+// validateConfigFile loads and initializes the plugin from a config file
+// without querying InfluxDB
+func validateConfigFile(path string, log telegraf.Logger) error {
+	plugin, err := loadConfigFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to load config file: %w", err)
+	}
 
-func (l *simpleLogger) Debug(args ...interface{}) {
-	log.Print(append([]interface{}{"DEBUG: "}, args...)...)
+	plugin.Log = log
+	return plugin.Init()
 }
 
-func (l *simpleLogger) Warnf(format string, args ...interface{}) {
-	log.Printf("WARN: "+format, args...)
-}
+// loadConfigFile reads the plugin settings from a Telegraf-style config file
+// containing an [[inputs.influxdb_input]] section. Unknown settings in that
+// section, e.g. misspelled ones, are an error.
+func loadConfigFile(path string) (*InfluxDBInput, error) {
+	conf, err := shim.LoadConfig(&path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkConfigKeys(path); err != nil {
+		return nil, err
+	}
 
-func (l *simpleLogger) Warn(args ...interface{}) {
-	log.Print(append([]interface{}{"WARN: "}, args...)...)
-}
+	plugin, ok := conf.Input.(*InfluxDBInput)
+	if !ok {
+		return nil, fmt.Errorf("no [[inputs.influxdb_input]] section found in %s", path)
+	}
 
-func (l *simpleLogger) Infof(format string, args ...interface{}) {
-	log.Printf("INFO: "+format, args...)
+	return plugin, nil
 }
 
-func (l *simpleLogger) Info(args ...interface{}) {
-	log.Print(append([]interface{}{"INFO: "}, args...)...)
-}
+// checkConfigKeys decodes the [[inputs.influxdb_input]] section of a config
+// file strictly, as the shim ignores settings that are not plugin options
+func checkConfigKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
 
-func (l *simpleLogger) Trace(args ...interface{}) {
-	log.Print(append([]interface{}{"TRACE: "}, args...)...)
-}
+	var conf struct {
+		Inputs struct {
+			InfluxDBInput []toml.Primitive `toml:"influxdb_input"`
+		} `toml:"inputs"`
+	}
+	md, err := toml.Decode(os.ExpandEnv(string(data)), &conf)
+	if err != nil {
+		return err
+	}
+	for _, primitive := range conf.Inputs.InfluxDBInput {
+		if err := md.PrimitiveDecode(primitive, &InfluxDBInput{}); err != nil {
+			return err
+		}
+	}
 
-func (l *simpleLogger) Tracef(format string, args ...interface{}) {
-	log.Printf("TRACE: "+format, args...)
+	var unknown []string
+	for _, key := range md.Undecoded() {
+		if len(key) > 2 && key[0] == "inputs" && key[1] == "influxdb_input" {
+			unknown = append(unknown, strings.Join(key[2:], "."))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown settings in [[inputs.influxdb_input]]: %s", strings.Join(unknown, ", "))
+	}
+	return nil
 }
 
 // simpleAccumulator is a basic accumulator implementation for standalone execution
 type simpleAccumulator struct {
-	metrics []telegraf.Metric
+	mu        sync.Mutex // Pushed metrics are added concurrently
+	metrics   []telegraf.Metric
+	precision time.Duration
+	log       telegraf.Logger // Defaults to a logger writing to stderr
 }
 
 func (a *simpleAccumulator) AddFields(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
@@ -607,9 +1475,14 @@ func (a *simpleAccumulator) AddFields(measurement string, fields map[string]inte
 	if len(t) > 0 {
 		timestamp = t[0]
 	}
+	if a.precision > 0 {
+		timestamp = timestamp.Round(a.precision)
+	}
 
 	m := metric.New(measurement, tags, fields, timestamp)
+	a.mu.Lock()
 	a.metrics = append(a.metrics, m)
+	a.mu.Unlock()
 }
 
 func (a *simpleAccumulator) AddGauge(measurement string, fields map[string]interface{}, tags map[string]string, t ...time.Time) {
@@ -629,17 +1502,71 @@ func (a *simpleAccumulator) AddHistogram(measurement string, fields map[string]i
 }
 
 func (a *simpleAccumulator) AddMetric(m telegraf.Metric) {
+	if a.precision > 0 {
+		m.SetTime(m.Time().Round(a.precision))
+	}
+	a.mu.Lock()
 	a.metrics = append(a.metrics, m)
+	a.mu.Unlock()
+}
+
+// take returns the metrics added so far and clears them
+func (a *simpleAccumulator) take() []telegraf.Metric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	metrics := a.metrics
+	a.metrics = nil
+	return metrics
 }
 
+// SetPrecision rounds the timestamps of all subsequently added metrics, like
+// Telegraf's accumulator does
 func (a *simpleAccumulator) SetPrecision(precision time.Duration) {
-	// Not implemented for simple accumulator
+	a.precision = precision
 }
 
 func (a *simpleAccumulator) AddError(err error) {
-	log.Printf("Accumulator error: %v", err)
+	logger := a.log
+	if logger == nil {
+		logger = &simpleLogger{}
+	}
+	logger.Errorf("Accumulator error: %v", err)
 }
 
+// WithTracking returns an accumulator reporting the delivery of tracked
+// metrics, which is confirmed once they are written to stdout
 func (a *simpleAccumulator) WithTracking(maxTracked int) telegraf.TrackingAccumulator {
-	return nil
+	return &simpleTrackingAccumulator{
+		simpleAccumulator: a,
+		delivered:         make(chan telegraf.DeliveryInfo, maxTracked),
+	}
+}
+
+// simpleTrackingAccumulator adds delivery tracking to the simpleAccumulator
+type simpleTrackingAccumulator struct {
+	*simpleAccumulator
+	delivered chan telegraf.DeliveryInfo
+}
+
+func (a *simpleTrackingAccumulator) AddTrackingMetric(m telegraf.Metric) telegraf.TrackingID {
+	tracked, id := metric.WithTracking(m, a.onDelivery)
+	a.AddMetric(tracked)
+	return id
+}
+
+func (a *simpleTrackingAccumulator) AddTrackingMetricGroup(group []telegraf.Metric) telegraf.TrackingID {
+	tracked, id := metric.WithGroupTracking(group, a.onDelivery)
+	for _, m := range tracked {
+		a.AddMetric(m)
+	}
+	return id
+}
+
+func (a *simpleTrackingAccumulator) Delivered() <-chan telegraf.DeliveryInfo {
+	return a.delivered
+}
+
+func (a *simpleTrackingAccumulator) onDelivery(info telegraf.DeliveryInfo) {
+	a.delivered <- info
 }
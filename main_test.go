@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -107,9 +110,9 @@ func TestIsNewMetric(t *testing.T) {
 	plugin := &InfluxDBInput{
 		TrackNewMetricsOnly: true,
 		MaxTrackedMetrics:   10000,
-		seenMetrics:         make(map[string]time.Time),
 		Log:                 &simpleLogger{},
 	}
+	plugin.tracker = newExactTracker(10000, 16, plugin.generateMetricKey, false)
 
 	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
 
@@ -159,61 +162,60 @@ func TestCleanupOldMetrics(t *testing.T) {
 	plugin := &InfluxDBInput{
 		TrackNewMetricsOnly: true,
 		trackingWindow:      1 * time.Hour,
-		seenMetrics:         make(map[string]time.Time),
 		Log:                 &simpleLogger{},
 	}
+	tracker := newExactTracker(10000, 16, plugin.generateMetricKey, false)
+	plugin.tracker = tracker
 
 	// Add an old metric
 	oldTime := time.Now().Add(-2 * time.Hour)
-	plugin.seenMetrics["old_metric_key"] = oldTime
+	tracker.add("old_metric_key", 0, oldTime)
 
 	// Add a recent metric
 	recentTime := time.Now().Add(-30 * time.Minute)
-	plugin.seenMetrics["recent_metric_key"] = recentTime
+	tracker.add("recent_metric_key", 0, recentTime)
 
 	// Clean up
 	plugin.cleanupOldMetrics()
 
 	// Old metric should be removed
-	if _, exists := plugin.seenMetrics["old_metric_key"]; exists {
+	if _, exists := tracker.lookup("old_metric_key"); exists {
 		t.Error("Expected old metric to be removed")
 	}
 
 	// Recent metric should still exist
-	if _, exists := plugin.seenMetrics["recent_metric_key"]; !exists {
+	if _, exists := tracker.lookup("recent_metric_key"); !exists {
 		t.Error("Expected recent metric to still exist")
 	}
 }
 
 // TestEvictOldestMetrics tests the eviction logic when max limit is reached
 func TestEvictOldestMetrics(t *testing.T) {
-	plugin := &InfluxDBInput{
-		TrackNewMetricsOnly: true,
-		MaxTrackedMetrics:   100,
-		seenMetrics:         make(map[string]time.Time),
-		Log:                 &simpleLogger{},
-	}
+	tracker := newMetricTracker(100)
 
 	// Add 110 metrics with different timestamps
 	baseTime := time.Now()
 	for i := 0; i < 110; i++ {
 		key := fmt.Sprintf("metric_%d", i)
-		plugin.seenMetrics[key] = baseTime.Add(time.Duration(i) * time.Minute)
+		tracker.add(key, 0, baseTime.Add(time.Duration(i)*time.Minute))
 	}
 
-	initialCount := len(plugin.seenMetrics)
-
-	// Trigger eviction (called internally by markMetricAsSeen)
-	plugin.evictOldestMetrics()
-
-	finalCount := len(plugin.seenMetrics)
-
-	// Should have removed ~10% of metrics
-	expectedRemoved := initialCount / 10
-	actualRemoved := initialCount - finalCount
+	// Should have evicted the 10 oldest metrics
+	if tracker.len() != 100 {
+		t.Errorf("Expected 100 tracked metrics, got %d", tracker.len())
+	}
+	if tracker.evicted() != 10 {
+		t.Errorf("Expected 10 evictions, got %d", tracker.evicted())
+	}
 
-	if actualRemoved < expectedRemoved-1 || actualRemoved > expectedRemoved+1 {
-		t.Errorf("Expected to remove approximately %d metrics, removed %d", expectedRemoved, actualRemoved)
+	for i := 0; i < 110; i++ {
+		_, exists := tracker.lookup(fmt.Sprintf("metric_%d", i))
+		if i < 10 && exists {
+			t.Errorf("Expected metric_%d to be evicted", i)
+		}
+		if i >= 10 && !exists {
+			t.Errorf("Expected metric_%d to still be tracked", i)
+		}
 	}
 }
 
@@ -268,9 +270,9 @@ func TestTrackingDisabled(t *testing.T) {
 	plugin := &InfluxDBInput{
 		TrackNewMetricsOnly: false,
 		MaxTrackedMetrics:   10000,
-		seenMetrics:         make(map[string]time.Time),
 		Log:                 &simpleLogger{},
 	}
+	plugin.tracker = newExactTracker(10000, 16, plugin.generateMetricKey, false)
 
 	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
 
@@ -289,10 +291,344 @@ func TestTrackingDisabled(t *testing.T) {
 	// but we don't call it when tracking is disabled
 	// The Gather method handles this logic
 
-	// Verify that seenMetrics map is empty (not used when tracking disabled)
+	// Verify that the tracker still records metrics (not used when tracking disabled)
 	plugin.markMetricAsSeen(m) // This should still work but won't be used
 
-	if len(plugin.seenMetrics) == 0 {
+	if plugin.tracker.stats().Entries == 0 {
 		t.Error("Expected metric to be tracked even if tracking is disabled (data structure still works)")
 	}
 }
+
+// TestLoadConfigFile tests loading the plugin settings from a config file
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugin.conf")
+	config := `
+[[inputs.influxdb_input]]
+  url = "http://influxdb:8181"
+  database = "plant"
+  query = "SELECT * FROM sensors"
+
+  [[inputs.influxdb_input.transform]]
+    columns = ["value"]
+    scale = 0.1
+    rename = "temperature_c"
+`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	plugin, err := loadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading config: %v", err)
+	}
+
+	if plugin.URL != "http://influxdb:8181" || plugin.Database != "plant" {
+		t.Errorf("Unexpected connection settings: url=%s database=%s", plugin.URL, plugin.Database)
+	}
+
+	if len(plugin.Transforms) != 1 || plugin.Transforms[0].Rename != "temperature_c" {
+		t.Errorf("Expected one transform rule renaming to temperature_c, got %+v", plugin.Transforms)
+	}
+}
+
+// TestContentDedupMode tests that content mode tracks each distinct field set
+func TestContentDedupMode(t *testing.T) {
+	plugin := &InfluxDBInput{
+		TrackNewMetricsOnly: true,
+		DedupMode:           "content",
+		MaxTrackedMetrics:   10000,
+		Log:                 &simpleLogger{},
+	}
+	plugin.tracker = newExactTracker(10000, 16, plugin.generateMetricKey, false)
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	original := MetricData{
+		Name:   "test_metric",
+		Tags:   map[string]string{"host": "server1"},
+		Fields: map[string]interface{}{"value": 42.0},
+		Time:   timestamp,
+	}
+	corrected := MetricData{
+		Name:   "test_metric",
+		Tags:   map[string]string{"host": "server1"},
+		Fields: map[string]interface{}{"value": 43.0},
+		Time:   timestamp,
+	}
+
+	plugin.markMetricAsSeen(original)
+
+	if plugin.isNewMetric(original) {
+		t.Error("Expected identical metric to not be new")
+	}
+
+	if !plugin.isNewMetric(corrected) {
+		t.Error("Expected metric with corrected value to be new in content mode")
+	}
+
+	// Key mode ignores the field values
+	plugin.DedupMode = "key"
+	plugin.tracker = newExactTracker(10000, 16, plugin.generateMetricKey, false)
+	plugin.markMetricAsSeen(original)
+
+	if plugin.isNewMetric(corrected) {
+		t.Error("Expected metric with corrected value to not be new in key mode")
+	}
+}
+
+// TestReemitOnChange tests that a point is re-emitted whenever its fields change
+func TestReemitOnChange(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{
+		TrackNewMetricsOnly: true,
+		DedupMode:           "content",
+		ReemitOnChange:      true,
+		Log:                 &simpleLogger{},
+	})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Unexpected error initializing plugin: %v", err)
+	}
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	values := []float64{42.0, 43.0, 42.0}
+	for idx, value := range values {
+		m := MetricData{
+			Name:   "test_metric",
+			Tags:   map[string]string{"host": "server1"},
+			Fields: map[string]interface{}{"value": value},
+			Time:   timestamp,
+		}
+
+		if !plugin.isNewMetric(m) {
+			t.Errorf("Expected change %d to value %v to be re-emitted", idx, value)
+		}
+		plugin.markMetricAsSeen(m)
+
+		if plugin.isNewMetric(m) {
+			t.Errorf("Expected unchanged value %v to not be re-emitted", value)
+		}
+	}
+
+	// Only a single entry is kept per point
+	if plugin.tracker.stats().Entries != 1 {
+		t.Errorf("Expected one tracked entry, got %d", plugin.tracker.stats().Entries)
+	}
+}
+
+// TestDedupModeValidation tests that invalid dedup settings are rejected
+func TestDedupModeValidation(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{DedupMode: "fuzzy"})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for invalid dedup_mode")
+	}
+
+	plugin = withConnection(&InfluxDBInput{ReemitOnChange: true})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for reemit_on_change without content mode")
+	}
+}
+
+// TestHashedMetricKey tests the fixed-size hashed key representation
+func TestHashedMetricKey(t *testing.T) {
+	plugin := &InfluxDBInput{DedupKeyFormat: "hash"}
+
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	m1 := MetricData{
+		Name: "test_metric",
+		Tags: map[string]string{
+			"host":     "server1",
+			"location": "building-7/floor-3/rack-12/unit-4",
+		},
+		Fields: map[string]interface{}{"value": 42.0},
+		Time:   timestamp,
+	}
+	m2 := MetricData{
+		Name: "test_metric",
+		Tags: map[string]string{
+			"host":     "server2",
+			"location": "building-7/floor-3/rack-12/unit-4",
+		},
+		Fields: map[string]interface{}{"value": 42.0},
+		Time:   timestamp,
+	}
+
+	key1 := plugin.generateMetricKey(m1)
+	if len(key1) != 16 {
+		t.Errorf("Expected 16 byte hashed key, got %d bytes", len(key1))
+	}
+
+	if key1 != plugin.generateMetricKey(m1) {
+		t.Error("Expected hashed key to be stable")
+	}
+
+	if key1 == plugin.generateMetricKey(m2) {
+		t.Error("Expected different hashed keys for metrics with different tags")
+	}
+}
+
+// TestTimestampPrecision tests that rounded timestamps are used for dedup and output
+func TestTimestampPrecision(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{
+		TrackNewMetricsOnly: true,
+		Precision:           "1s",
+		Log:                 &simpleLogger{},
+	})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	first := plugin.convertRowToMetric(map[string]interface{}{
+		"time":  "2024-01-01T12:00:00.200Z",
+		"host":  "server1",
+		"value": 1.0,
+	})
+	second := plugin.convertRowToMetric(map[string]interface{}{
+		"time":  "2024-01-01T12:00:00.400Z",
+		"host":  "server1",
+		"value": 1.0,
+	})
+
+	expected := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	if !first.Time.Equal(expected) || !second.Time.Equal(expected) {
+		t.Fatalf("Expected both timestamps to be rounded to %v, got %v and %v", expected, first.Time, second.Time)
+	}
+
+	// Both points end up at the same second downstream and must only be forwarded once
+	now := time.Now()
+	if !plugin.tracker.observe(*first, now) {
+		t.Error("Expected first metric to be new")
+	}
+	if plugin.tracker.observe(*second, now) {
+		t.Error("Expected metric rounded to the same second to be a duplicate")
+	}
+
+	acc := &simpleAccumulator{}
+	acc.SetPrecision(time.Second)
+	acc.AddFields("test_metric", map[string]interface{}{"value": 1.0}, nil, expected.Add(600*time.Millisecond))
+	if got := acc.metrics[0].Time(); !got.Equal(expected.Add(time.Second)) {
+		t.Errorf("Expected accumulator to round to %v, got %v", expected.Add(time.Second), got)
+	}
+
+	invalid := withConnection(&InfluxDBInput{Precision: "soon", Log: &simpleLogger{}})
+	if err := invalid.Init(); err == nil {
+		t.Error("Expected error for invalid precision")
+	}
+}
+
+// withConnection fills in the connection settings required by Init
+func withConnection(plugin *InfluxDBInput) *InfluxDBInput {
+	plugin.URL = "http://localhost:8181"
+	plugin.Database = "test"
+	plugin.Query = "SELECT * FROM cpu"
+	return plugin
+}
+
+// TestInitValidation tests that invalid settings are rejected by Init
+func TestInitValidation(t *testing.T) {
+	garbage := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(garbage, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*InfluxDBInput)
+	}{
+		{"missing url", func(p *InfluxDBInput) { p.URL = "" }},
+		{"url without scheme", func(p *InfluxDBInput) { p.URL = "localhost:8181" }},
+		{"unsupported scheme", func(p *InfluxDBInput) { p.URL = "ftp://localhost" }},
+		{"url without host", func(p *InfluxDBInput) { p.URL = "http://" }},
+		{"missing database", func(p *InfluxDBInput) { p.Database = "" }},
+		{"missing query", func(p *InfluxDBInput) { p.Query = " " }},
+		{"timeout without unit", func(p *InfluxDBInput) { p.Timeout = "5" }},
+		{"negative timeout", func(p *InfluxDBInput) { p.Timeout = "-1s" }},
+		{"invalid tracking window", func(p *InfluxDBInput) { p.MetricTrackingWindow = "forever" }},
+		{"negative max tracked metrics", func(p *InfluxDBInput) { p.MaxTrackedMetrics = -1 }},
+		{"missing CA file", func(p *InfluxDBInput) { p.TLSCA = filepath.Join(t.TempDir(), "missing.pem") }},
+		{"invalid CA file", func(p *InfluxDBInput) { p.TLSCA = garbage }},
+		{"cert without key", func(p *InfluxDBInput) { p.TLSCert = garbage }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := withConnection(&InfluxDBInput{Log: &simpleLogger{}})
+			tt.modify(plugin)
+			if err := plugin.Init(); err == nil {
+				t.Error("Expected error from Init")
+			}
+		})
+	}
+
+	plugin := withConnection(&InfluxDBInput{Log: &simpleLogger{}})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Unexpected error for valid config: %v", err)
+	}
+	if plugin.timeout != 5*time.Second || plugin.trackingWindow != time.Hour {
+		t.Errorf("Expected default durations, got timeout=%v window=%v", plugin.timeout, plugin.trackingWindow)
+	}
+}
+
+// TestValidateConfigFile tests validating config files
+func TestValidateConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.conf")
+	invalid := filepath.Join(dir, "invalid.conf")
+
+	config := `
+[[inputs.influxdb_input]]
+  url = "http://influxdb:8181"
+  database = "plant"
+  query = "SELECT * FROM sensors"
+  timeout = "%s"
+`
+	if err := os.WriteFile(valid, []byte(fmt.Sprintf(config, "10s")), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if err := os.WriteFile(invalid, []byte(fmt.Sprintf(config, "10 seconds")), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if err := validateConfigFile(valid, &simpleLogger{}); err != nil {
+		t.Errorf("Unexpected error for valid config: %v", err)
+	}
+	if err := validateConfigFile(invalid, &simpleLogger{}); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Expected timeout error for invalid config, got %v", err)
+	}
+	if err := validateConfigFile(filepath.Join(dir, "missing.conf"), &simpleLogger{}); err == nil {
+		t.Error("Expected error for missing config file")
+	}
+
+	// Misspelled settings are not silently ignored
+	misspelled := filepath.Join(dir, "misspelled.conf")
+	config = `
+[[inputs.influxdb_input]]
+  url = "http://influxdb:8181"
+  database = "plant"
+  query = "SELECT * FROM sensors"
+  dedup_strateg = "bloom"
+
+  [[inputs.influxdb_input.transform]]
+    columns = ["value"]
+    scael = 0.1
+`
+	if err := os.WriteFile(misspelled, []byte(config), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	err := validateConfigFile(misspelled, &simpleLogger{})
+	if err == nil || !strings.Contains(err.Error(), "dedup_strateg") || !strings.Contains(err.Error(), "transform.scael") {
+		t.Errorf("Expected error naming the unknown settings, got %v", err)
+	}
+}
+
+// TestAccumulatorErrorLogged tests that accumulator errors go through the
+// configured logger and its format
+func TestAccumulatorErrorLogged(t *testing.T) {
+	var buf strings.Builder
+	acc := &simpleAccumulator{log: &simpleLogger{json: true, output: &buf}}
+	acc.AddError(fmt.Errorf("query failed"))
+
+	if !strings.Contains(buf.String(), `"level":"error"`) || !strings.Contains(buf.String(), "query failed") {
+		t.Errorf("Expected JSON error line, got %q", buf.String())
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/plugins/serializers/csv"
+	"github.com/influxdata/telegraf/plugins/serializers/json"
+	"github.com/influxdata/telegraf/plugins/serializers/prometheus"
+)
+
+// outputFormats lists the formats supported by the standalone binary
+var outputFormats = []string{"influx", "json", "csv", "prometheus"}
+
+// newSerializer creates the serializer for the given output format. Line
+// protocol uses the plugin's own serializer, all other formats are backed by
+// Telegraf's serializers with their default settings, except for sorted
+// Prometheus output. Line protocol and JSON timestamps are written in the unit
+// matching precision, the other formats keep their own timestamp units.
+func newSerializer(format string, precision time.Duration) (telegraf.Serializer, error) {
+	var serializer telegraf.Serializer
+	switch format {
+	case "", "influx":
+		return &lineProtocolSerializer{precision: precision}, nil
+	case "json":
+		serializer = &json.Serializer{TimestampUnits: config.Duration(timestampUnit(precision))}
+	case "csv":
+		serializer = &csv.Serializer{Header: true}
+	case "prometheus":
+		serializer = &prometheus.Serializer{FormatConfig: prometheus.FormatConfig{SortMetrics: true}}
+	default:
+		return nil, fmt.Errorf("unknown output format %q, expected one of %v", format, outputFormats)
+	}
+
+	if init, ok := serializer.(telegraf.Initializer); ok {
+		if err := init.Init(); err != nil {
+			return nil, fmt.Errorf("failed to initialize %s serializer: %w", format, err)
+		}
+	}
+
+	return serializer, nil
+}
+
+// metricOutput is where the standalone binary delivers gathered metrics.
+// Implementations accept or reject the metrics to confirm their delivery.
+type metricOutput interface {
+	Write(ctx context.Context, metrics []telegraf.Metric) error
+}
+
+// streamOutput writes the metrics to a stream, usually stdout
+type streamOutput struct {
+	w          io.Writer
+	serializer telegraf.Serializer
+	log        telegraf.Logger
+}
+
+func (o *streamOutput) Write(_ context.Context, metrics []telegraf.Metric) error {
+	return writeMetrics(o.w, o.serializer, metrics, o.log)
+}
+
+// writeMetrics serializes the metrics and writes them to w. Metrics that
+// cannot be serialized are skipped with a warning. Written metrics are
+// accepted and metrics not written due to an error are rejected, which
+// confirms or fails the delivery of tracked metrics.
+func writeMetrics(w io.Writer, serializer telegraf.Serializer, metrics []telegraf.Metric, log telegraf.Logger) error {
+	// Prometheus groups samples into metric families and needs the whole batch
+	if _, ok := serializer.(*prometheus.Serializer); ok {
+		data, err := serializer.SerializeBatch(metrics)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		for _, m := range metrics {
+			if err != nil {
+				m.Reject()
+			} else {
+				m.Accept()
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write metrics: %w", err)
+		}
+		return nil
+	}
+
+	for idx, m := range metrics {
+		data, err := serializer.Serialize(m)
+		if err != nil {
+			log.Warnf("Skipping metric: %v", err)
+			m.Drop()
+			continue
+		}
+		if _, err := w.Write(data); err != nil {
+			for _, unwritten := range metrics[idx:] {
+				unwritten.Reject()
+			}
+			return err
+		}
+		m.Accept()
+	}
+
+	return nil
+}
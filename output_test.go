@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/metric"
+)
+
+// TestOutputFormats tests that every supported format produces output
+func TestOutputFormats(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metrics := []telegraf.Metric{
+		metric.New("cpu", map[string]string{"host": "server1"}, map[string]interface{}{"usage": 12.5}, timestamp),
+		metric.New("cpu", map[string]string{"host": "server2"}, map[string]interface{}{"usage": 7.5}, timestamp),
+	}
+
+	expected := map[string]string{
+		"influx":     "cpu,host=server1 usage=12.5 1704110400000000000\n",
+		"json":       `{"fields":{"usage":12.5},"name":"cpu","tags":{"host":"server1"},"timestamp":1704110400000000000}`,
+		"csv":        "timestamp,measurement,host,usage\n1704110400,cpu,server1,12.5\n",
+		"prometheus": "# TYPE cpu_usage untyped\ncpu_usage{host=\"server1\"} 12.5\ncpu_usage{host=\"server2\"} 7.5\n",
+	}
+
+	for _, format := range outputFormats {
+		t.Run(format, func(t *testing.T) {
+			serializer, err := newSerializer(format, 0)
+			if err != nil {
+				t.Fatalf("Unexpected error creating serializer: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := writeMetrics(&buf, serializer, metrics, &simpleLogger{}); err != nil {
+				t.Fatalf("Unexpected error writing metrics: %v", err)
+			}
+
+			if !strings.Contains(buf.String(), expected[format]) {
+				t.Errorf("Expected output to contain:\n%s\ngot:\n%s", expected[format], buf.String())
+			}
+		})
+	}
+}
+
+// TestOutputJSONTimestamp tests that JSON timestamps keep sub-second parts
+// in the unit of the precision
+func TestOutputJSONTimestamp(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 123456789, time.UTC)
+	m := metric.New("cpu", nil, map[string]interface{}{"usage": 12.5}, timestamp)
+
+	tests := []struct {
+		precision time.Duration
+		expected  string
+	}{
+		{0, `"timestamp":1704110400123456789`},
+		{time.Millisecond, `"timestamp":1704110400123`},
+		{time.Second, `"timestamp":1704110400}`},
+	}
+	for _, tt := range tests {
+		serializer, err := newSerializer("json", tt.precision)
+		if err != nil {
+			t.Fatalf("Unexpected error creating serializer: %v", err)
+		}
+		out, err := serializer.Serialize(m)
+		if err != nil {
+			t.Fatalf("Unexpected error serializing: %v", err)
+		}
+		if !strings.Contains(string(out), tt.expected) {
+			t.Errorf("Precision %v: expected %s, got %s", tt.precision, tt.expected, out)
+		}
+	}
+}
+
+// TestOutputSkipsInvalidMetrics tests that unserializable metrics are skipped
+func TestOutputSkipsInvalidMetrics(t *testing.T) {
+	timestamp := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	metrics := []telegraf.Metric{
+		metric.New("bad", nil, map[string]interface{}{"value": math.NaN()}, timestamp),
+		metric.New("good", nil, map[string]interface{}{"value": 1.0}, timestamp),
+	}
+
+	serializer, err := newSerializer("influx", 0)
+	if err != nil {
+		t.Fatalf("Unexpected error creating serializer: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, serializer, metrics, &simpleLogger{}); err != nil {
+		t.Fatalf("Unexpected error writing metrics: %v", err)
+	}
+
+	if buf.String() != "good value=1 1704110400000000000\n" {
+		t.Errorf("Expected only the valid metric, got %q", buf.String())
+	}
+}
+
+// TestUnknownOutputFormat tests that unknown formats are rejected
+func TestUnknownOutputFormat(t *testing.T) {
+	if _, err := newSerializer("xml", 0); err == nil {
+		t.Error("Expected error for unknown output format")
+	}
+}
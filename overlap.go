@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// overlapPolicies lists the supported overlap_policy values
+var overlapPolicies = []string{"skip", "queue_one", "cancel_previous"}
+
+// gatherGuard allows only one gather of the query to run at a time
+type gatherGuard struct {
+	mu      sync.Mutex
+	running bool
+	started time.Time
+	cancel  context.CancelFunc // Cancels the running gather
+	done    chan struct{}      // Closed when the running gather finishes
+	queued  bool               // A gather waits for the running one
+}
+
+// beginGather waits for or cancels a running gather according to the overlap
+// policy. It returns false if this gather has to be skipped, otherwise a
+// context cancelled by a later gather and a function to call once done.
+func (i *InfluxDBInput) beginGather(ctx context.Context) (context.Context, func(), bool) {
+	g := &i.overlap
+	g.mu.Lock()
+	for g.running {
+		running := time.Since(g.started).Round(time.Millisecond)
+		done := g.done
+
+		switch i.OverlapPolicy {
+		case "queue_one":
+			if g.queued {
+				g.mu.Unlock()
+				i.skipCycles(1, fmt.Sprintf("previous gather running for %s and another one queued", running))
+				return nil, nil, false
+			}
+			g.queued = true
+			g.mu.Unlock()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				g.mu.Lock()
+				g.queued = false
+				g.mu.Unlock()
+				return nil, nil, false
+			}
+
+			g.mu.Lock()
+			g.queued = false
+		case "cancel_previous":
+			g.cancel()
+			g.mu.Unlock()
+			i.skipCycles(1, fmt.Sprintf("cancelled previous gather running for %s", running))
+			<-done
+			g.mu.Lock()
+		default:
+			g.mu.Unlock()
+			i.skipCycles(1, fmt.Sprintf("previous gather still running for %s", running))
+			return nil, nil, false
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	g.running = true
+	g.started = time.Now()
+	g.cancel = cancel
+	g.done = done
+	g.mu.Unlock()
+
+	return ctx, func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		cancel()
+		close(done)
+		g.running = false
+	}, true
+}
+
+// skipCycles counts and logs gather cycles lost to a slow query
+func (i *InfluxDBInput) skipCycles(count int, reason string) {
+	i.stats.cyclesSkipped.Incr(int64(count))
+	i.Log.Warnf("Skipped %d gather cycle(s): %s, consider a longer interval", count, reason)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// overlapServer counts the queries received, blocking the first one until
+// release is closed or the query is cancelled
+type overlapServer struct {
+	requests  atomic.Int64
+	cancelled atomic.Bool
+	release   chan struct{}
+}
+
+// newOverlapPlugin starts a plugin scheduling its query every 10ms whose first
+// query blocks, so the following scheduled runs overlap it
+func newOverlapPlugin(t *testing.T, policy string) (*InfluxDBInput, *overlapServer) {
+	overlap := &overlapServer{release: make(chan struct{})}
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Read the body, so a closed connection cancels the request context
+		io.Copy(io.Discard, r.Body)
+		if overlap.requests.Add(1) == 1 {
+			close(started)
+			select {
+			case <-overlap.release:
+			case <-r.Context().Done():
+				overlap.cancelled.Store(true)
+				return
+			}
+		}
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}]`))
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() {
+		select {
+		case <-overlap.release:
+		default:
+			close(overlap.release)
+		}
+	})
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:     t.Name(),
+		QueryInterval: "10ms",
+		OverlapPolicy: policy,
+		Log:           &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	if err := plugin.Start(&simpleAccumulator{}); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	t.Cleanup(plugin.Stop)
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the first query")
+	}
+
+	return plugin, overlap
+}
+
+// waitUntil polls cond until it holds, failing the test after a timeout
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestOverlapSkip tests that scheduled runs overlapping a running query are
+// skipped
+func TestOverlapSkip(t *testing.T) {
+	plugin, overlap := newOverlapPlugin(t, "")
+
+	waitUntil(t, "skipped cycles", func() bool { return plugin.stats.cyclesSkipped.Get() >= 2 })
+	if count := overlap.requests.Load(); count != 1 {
+		t.Errorf("Expected the overlapping runs not to query, got %d queries", count)
+	}
+
+	// The schedule continues once the query finishes
+	close(overlap.release)
+	waitUntil(t, "the next query", func() bool { return overlap.requests.Load() >= 2 })
+}
+
+// TestOverlapQueueOne tests that one overlapping scheduled run waits for the
+// running query and further ones are skipped
+func TestOverlapQueueOne(t *testing.T) {
+	plugin, overlap := newOverlapPlugin(t, "queue_one")
+
+	waitUntil(t, "skipped cycles", func() bool { return plugin.stats.cyclesSkipped.Get() >= 1 })
+	plugin.overlap.mu.Lock()
+	queued := plugin.overlap.queued
+	plugin.overlap.mu.Unlock()
+	if !queued {
+		t.Error("Expected a scheduled run to be queued")
+	}
+	if count := overlap.requests.Load(); count != 1 {
+		t.Errorf("Expected the overlapping runs not to query yet, got %d queries", count)
+	}
+
+	close(overlap.release)
+	waitUntil(t, "the queued query", func() bool { return overlap.requests.Load() >= 2 })
+}
+
+// TestOverlapCancelPrevious tests that a scheduled run cancels the running
+// query
+func TestOverlapCancelPrevious(t *testing.T) {
+	plugin, overlap := newOverlapPlugin(t, "cancel_previous")
+
+	waitUntil(t, "a successful query", func() bool {
+		return plugin.status(time.Now()).LastSuccess != nil
+	})
+	waitUntil(t, "the cancelled query", overlap.cancelled.Load)
+	if skipped := plugin.stats.cyclesSkipped.Get(); skipped < 1 {
+		t.Errorf("Expected at least 1 skipped cycle, got %d", skipped)
+	}
+}
+
+// TestOverlapValidation tests that an unknown overlap policy is rejected
+func TestOverlapValidation(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{OverlapPolicy: "wait", Log: &simpleLogger{}})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for invalid overlap_policy")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+)
+
+// pushPath is the endpoint accepting batches from a WAL flush trigger
+const pushPath = "/push"
+
+// pushMaxBodySize limits the size of a pushed batch after decompression
+const pushMaxBodySize = 32 << 20
+
+// pushTableBatch holds the rows written to a single table, as handed to a WAL
+// flush trigger of the InfluxDB3 processing engine
+type pushTableBatch struct {
+	TableName string                   `json:"table_name"`
+	Rows      []map[string]interface{} `json:"rows"`
+}
+
+// pushError is a rejected push with the status code to respond with
+type pushError struct {
+	status  int
+	message string
+}
+
+func (e *pushError) Error() string {
+	return e.message
+}
+
+// parsePush validates the push listener settings
+func (i *InfluxDBInput) parsePush() error {
+	if i.PushListen == "" {
+		if i.PushToken != "" {
+			return fmt.Errorf("push_token requires push_listen")
+		}
+		return nil
+	}
+	if i.PushToken == "" {
+		return fmt.Errorf("push_listen requires push_token to authenticate the trigger")
+	}
+
+	i.pushAckTimeout = 30 * time.Second
+	if i.PushAckTimeout != "" {
+		var err error
+		i.pushAckTimeout, err = time.ParseDuration(i.PushAckTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid push_ack_timeout: %w", err)
+		}
+		if i.pushAckTimeout <= 0 {
+			return fmt.Errorf("push_ack_timeout must be positive, got %s", i.PushAckTimeout)
+		}
+	}
+
+	i.pushParser = &influx.Parser{}
+	if err := i.pushParser.Init(); err != nil {
+		return fmt.Errorf("failed to initialize line protocol parser: %w", err)
+	}
+	return nil
+}
+
+// pushHandler serves the push endpoint, adding the new metrics of every batch
+// to acc. A batch is acknowledged with 204 once its metrics are accepted, with
+// delivery_guarantee only once Telegraf has delivered them.
+func (i *InfluxDBInput) pushHandler(acc telegraf.Accumulator) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+pushPath, func(w http.ResponseWriter, r *http.Request) {
+		i.stats.pushRequests.Incr(1)
+		if err := i.handlePush(r, acc); err != nil {
+			i.stats.pushErrors.Incr(1)
+			status := http.StatusInternalServerError
+			var perr *pushError
+			if errors.As(err, &perr) {
+				status = perr.status
+			}
+			if status == http.StatusServiceUnavailable {
+				w.Header().Set("Retry-After", "1")
+			}
+			i.Log.Warnf("Rejected push from %s: %v", r.RemoteAddr, err)
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// handlePush authenticates, decodes and emits a pushed batch
+func (i *InfluxDBInput) handlePush(r *http.Request, acc telegraf.Accumulator) error {
+	if !i.pushAuthorized(r) {
+		return &pushError{status: http.StatusUnauthorized, message: "invalid or missing token"}
+	}
+
+	ctx, span := i.startSpan(r.Context(), "push")
+	defer span.End()
+
+	metrics, err := i.readPush(r)
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+
+	var delivered chan bool
+	if i.DeliveryGuarantee {
+		delivered = make(chan bool, 1)
+	}
+	count, err := i.emit(ctx, acc, metrics, delivered)
+	if errors.Is(err, errDeliveryBacklog) {
+		return &pushError{status: http.StatusServiceUnavailable, message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	i.Log.Debugf("Received %d pushed metrics, propagated %d new metrics", len(metrics), count)
+
+	if delivered == nil || count == 0 {
+		return nil
+	}
+
+	// Metrics of unconfirmed pushes are released once their delivery fails,
+	// so the retry of the trigger emits them again
+	timer := time.NewTimer(i.pushAckTimeout)
+	defer timer.Stop()
+	select {
+	case ok := <-delivered:
+		if !ok {
+			return &pushError{status: http.StatusServiceUnavailable, message: "delivery of the metrics failed"}
+		}
+		return nil
+	case <-timer.C:
+		return &pushError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("delivery not confirmed within %s", i.pushAckTimeout)}
+	case <-i.ctx.Done():
+		return &pushError{status: http.StatusServiceUnavailable, message: "plugin stopping"}
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+// pushAuthorized checks the shared token of a push, sent as a Bearer or
+// Token authorization
+func (i *InfluxDBInput) pushAuthorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if token, ok := strings.CutPrefix(auth, scheme); ok {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(i.PushToken)) == 1
+		}
+	}
+	return false
+}
+
+// readPush reads and decodes the body of a push into metrics, applying the
+// precision and the transforms
+func (i *InfluxDBInput) readPush(r *http.Request) ([]MetricData, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, &pushError{status: http.StatusBadRequest, message: fmt.Sprintf("invalid gzip body: %v", err)}
+		}
+		defer zr.Close()
+		body = zr
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, pushMaxBodySize+1))
+	if err != nil {
+		return nil, &pushError{status: http.StatusBadRequest, message: fmt.Sprintf("failed to read body: %v", err)}
+	}
+	if len(data) > pushMaxBodySize {
+		return nil, &pushError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("body larger than %d bytes", pushMaxBodySize)}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		rows, err := decodePushBatches(data)
+		if err != nil {
+			return nil, &pushError{status: http.StatusBadRequest, message: err.Error()}
+		}
+		metrics, _, _ := i.convertRows(rows)
+		return metrics, nil
+	}
+
+	parsed, err := i.pushParser.Parse(data)
+	if err != nil {
+		return nil, &pushError{status: http.StatusBadRequest, message: err.Error()}
+	}
+	i.stats.rowsReturned.Incr(int64(len(parsed)))
+	metrics := make([]MetricData, 0, len(parsed))
+	for _, pm := range parsed {
+		m := MetricData{Name: pm.Name(), Tags: pm.Tags(), Fields: pm.Fields(), Time: pm.Time()}
+		if i.precision > 0 {
+			m.Time = m.Time.Round(i.precision)
+		}
+		if !i.applyTransforms(&m) {
+			i.stats.rowsFiltered.Incr(1)
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
+
+// decodePushBatches converts the table batches of a WAL flush trigger into
+// rows like those of a query result. The table becomes the measurement and
+// the nanosecond "time" column an RFC 3339 timestamp.
+func decodePushBatches(data []byte) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var batches []pushTableBatch
+	if err := decoder.Decode(&batches); err != nil {
+		return nil, fmt.Errorf("failed to parse table batches: %w", err)
+	}
+
+	var rows []map[string]interface{}
+	for _, batch := range batches {
+		if batch.TableName == "" {
+			return nil, fmt.Errorf("table batch without table_name")
+		}
+		for _, row := range batch.Rows {
+			for key, value := range row {
+				number, ok := value.(json.Number)
+				if !ok {
+					continue
+				}
+				if key == "time" {
+					ns, err := number.Int64()
+					if err != nil {
+						return nil, fmt.Errorf("invalid time %s in table %s", number, batch.TableName)
+					}
+					row[key] = time.Unix(0, ns).UTC().Format(time.RFC3339Nano)
+					continue
+				}
+				// Numbers are decoded as floats like in query results
+				f, err := number.Float64()
+				if err != nil {
+					return nil, fmt.Errorf("invalid number %s in table %s", number, batch.TableName)
+				}
+				row[key] = f
+			}
+			row["_measurement"] = batch.TableName
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// pushHousekeeping replaces the query of a gather when metrics are only
+// pushed, keeping the tracker, health and self-monitoring up to date
+func (i *InfluxDBInput) pushHousekeeping(acc telegraf.Accumulator) {
+	now := time.Now()
+	i.health.gatherStarted(now)
+	i.health.gatherFinished(now, nil)
+	if i.TrackNewMetricsOnly {
+		i.cleanupOldMetrics()
+	}
+	i.reportInternalMetrics(acc)
+}
+
+// startPushServer starts accepting pushes on push_listen
+func (i *InfluxDBInput) startPushServer(acc telegraf.Accumulator) error {
+	listener, err := net.Listen("tcp", i.PushListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on push_listen %q: %w", i.PushListen, err)
+	}
+
+	server := &http.Server{
+		Handler:           i.pushHandler(acc),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	i.pushServer = server
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			i.Log.Errorf("Push server failed: %v", err)
+		}
+	}()
+	i.Log.Infof("Accepting pushes on %s%s", listener.Addr(), pushPath)
+
+	return nil
+}
+
+// stopPushServer shuts the push server down, waiting for running pushes
+func (i *InfluxDBInput) stopPushServer() {
+	if i.pushServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := i.pushServer.Shutdown(ctx); err != nil {
+		i.Log.Errorf("Failed to stop push server: %v", err)
+	}
+	i.pushServer = nil
+}
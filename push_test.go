@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPushPlugin creates a started plugin accepting pushes on a test server
+func newPushPlugin(t *testing.T, modify func(*InfluxDBInput)) (*InfluxDBInput, *simpleAccumulator, string) {
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		PushListen:          "127.0.0.1:0",
+		PushToken:           "secret",
+		Log:                 &simpleLogger{},
+	})
+	if modify != nil {
+		modify(plugin)
+	}
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Start(acc); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	t.Cleanup(plugin.Stop)
+
+	server := httptest.NewServer(plugin.pushHandler(acc))
+	t.Cleanup(server.Close)
+	return plugin, acc, server.URL + pushPath
+}
+
+// push posts a batch and returns the response status code
+func push(t *testing.T, url, token, contentType string, body []byte, gzipped bool) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestPushLineProtocol tests that pushed line protocol is deduplicated like
+// query results and that invalid pushes are rejected
+func TestPushLineProtocol(t *testing.T) {
+	plugin, acc, url := newPushPlugin(t, nil)
+
+	body := []byte("cpu,host=server1 usage=1.5 1704110400000000000\ncpu,host=server2 usage=2.5 1704110400000000000\n")
+	for range 2 {
+		if status := push(t, url, "secret", "text/plain; charset=utf-8", body, false); status != http.StatusNoContent {
+			t.Fatalf("Expected status 204, got %d", status)
+		}
+	}
+	metrics := acc.take()
+	if len(metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(metrics))
+	}
+	if tag, _ := metrics[0].GetTag("host"); metrics[0].Name() != "cpu" || tag != "server1" {
+		t.Errorf("Unexpected metric %v", metrics[0])
+	}
+
+	if status := push(t, url, "wrong", "text/plain", body, false); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", status)
+	}
+	if status := push(t, url, "secret", "text/plain", []byte("cpu usage="), false); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid line protocol, got %d", status)
+	}
+	if status := push(t, url, "secret", "text/plain", body, true); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid gzip body, got %d", status)
+	}
+	if got := plugin.stats.pushErrors.Get(); got != 3 {
+		t.Errorf("Expected 3 push errors, got %d", got)
+	}
+}
+
+// TestPushTableBatches tests the JSON table batches of a WAL flush trigger
+func TestPushTableBatches(t *testing.T) {
+	_, acc, url := newPushPlugin(t, func(p *InfluxDBInput) {
+		p.Transforms = []TransformRule{{Columns: []string{"usage"}, Scale: scale(100)}}
+	})
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(`[{"table_name": "cpu", "rows": [{"host": "server1", "usage": 0.5, "time": 1704110400123456789}]}]`))
+	zw.Close()
+	if status := push(t, url, "secret", "application/json", body.Bytes(), true); status != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", status)
+	}
+
+	metrics := acc.take()
+	if len(metrics) != 1 {
+		t.Fatalf("Expected 1 metric, got %d", len(metrics))
+	}
+	m := metrics[0]
+	if tag, _ := m.GetTag("host"); m.Name() != "cpu" || tag != "server1" {
+		t.Errorf("Unexpected metric %v", m)
+	}
+	if value, _ := m.GetField("usage"); value != 50.0 {
+		t.Errorf("Expected transformed usage 50, got %v", value)
+	}
+	if expected := time.Unix(0, 1704110400123456789); !m.Time().Equal(expected) {
+		t.Errorf("Expected time %s, got %s", expected, m.Time())
+	}
+
+	if status := push(t, url, "secret", "application/json", []byte(`[{"rows": []}]`), false); status != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a batch without table, got %d", status)
+	}
+}
+
+// TestPushDeliveryGuarantee tests that a push is only acknowledged once its
+// metrics are delivered
+func TestPushDeliveryGuarantee(t *testing.T) {
+	plugin, acc, url := newPushPlugin(t, func(p *InfluxDBInput) {
+		p.DeliveryGuarantee = true
+	})
+
+	body := []byte("cpu,host=server1 usage=1.5 1704110400000000000\n")
+	pushAndDeliver := func(accept bool) int {
+		status := make(chan int, 1)
+		go func() { status <- push(t, url, "secret", "text/plain", body, false) }()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			acc.mu.Lock()
+			pending := len(acc.metrics)
+			acc.mu.Unlock()
+			if pending > 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for pushed metrics")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		for _, m := range acc.take() {
+			if accept {
+				m.Accept()
+			} else {
+				m.Reject()
+			}
+		}
+		return <-status
+	}
+
+	if status := pushAndDeliver(false); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 for a failed delivery, got %d", status)
+	}
+	waitDelivered(t, plugin)
+	if status := pushAndDeliver(true); status != http.StatusNoContent {
+		t.Errorf("Expected status 204 once delivered, got %d", status)
+	}
+
+	// Delivered metrics are acknowledged immediately
+	if status := push(t, url, "secret", "text/plain", body, false); status != http.StatusNoContent {
+		t.Errorf("Expected status 204 for seen metrics, got %d", status)
+	}
+}
+
+// TestPushOnly tests that the query may be omitted when metrics are pushed
+func TestPushOnly(t *testing.T) {
+	plugin, acc, _ := newPushPlugin(t, func(p *InfluxDBInput) {
+		p.URL = "http://127.0.0.1:1"
+		p.Query = ""
+	})
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ready, reason := plugin.health.ready(time.Now(), 3); !ready {
+		t.Errorf("Expected push-only plugin to be ready, got %q", reason)
+	}
+	if err := plugin.runQueryTest(&strings.Builder{}); err == nil {
+		t.Error("Expected error testing without a query")
+	}
+}
+
+// TestPushOptions tests invalid push settings
+func TestPushOptions(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*InfluxDBInput)
+	}{
+		{"listen without token", func(p *InfluxDBInput) { p.PushListen = ":8090" }},
+		{"token without listen", func(p *InfluxDBInput) { p.PushToken = "secret" }},
+		{"invalid ack timeout", func(p *InfluxDBInput) { p.PushListen, p.PushToken, p.PushAckTimeout = ":8090", "secret", "soon" }},
+		{"zero ack timeout", func(p *InfluxDBInput) { p.PushListen, p.PushToken, p.PushAckTimeout = ":8090", "secret", "0s" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plugin := withConnection(&InfluxDBInput{Log: &simpleLogger{}})
+			tt.modify(plugin)
+			if err := plugin.Init(); err == nil {
+				t.Error("Expected error from Init")
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runQueryTest runs the configured query once and writes a report of how the
+// response is processed to w: the raw response, the tags and fields of every
+// row, rows that are dropped and what deduplication would do. Nothing is
+// emitted, but the tracker records the metrics like a regular gather.
+func (i *InfluxDBInput) runQueryTest(w io.Writer) error {
+	if strings.TrimSpace(i.Query) == "" {
+		return fmt.Errorf("no query to test, metrics are only pushed")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.timeout)
+	defer cancel()
+
+	fmt.Fprintf(w, "URL:      %s\n", i.URL)
+	fmt.Fprintf(w, "Database: %s\n", i.Database)
+	start, _ := i.queryWindow(time.Now())
+	query := i.renderQuery(start, time.Now())
+	fmt.Fprintf(w, "Query:    %s\n\n", query)
+
+	body, err := i.executeQuery(ctx, query)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Raw response (%d bytes):\n%s\n\n", len(body), strings.TrimSpace(string(body)))
+
+	rows, err := i.parseResponse(body)
+	if err != nil {
+		return err
+	}
+
+	var emitted, dropped, duplicates int
+	now := time.Now()
+	for idx, row := range rows {
+		fmt.Fprintf(w, "Row %d:\n", idx+1)
+
+		m := i.convertRowToMetric(row)
+		if m == nil {
+			fmt.Fprintf(w, "  dropped:     no fields\n")
+			dropped++
+			continue
+		}
+		writeMetricData(w, m)
+
+		if len(i.Transforms) > 0 {
+			if !i.applyTransforms(m) {
+				fmt.Fprintf(w, "  dropped:     by transform\n")
+				dropped++
+				continue
+			}
+			fmt.Fprintf(w, "  transformed:\n")
+			writeMetricData(w, m)
+		}
+
+		switch {
+		case !i.TrackNewMetricsOnly:
+			fmt.Fprintf(w, "  dedup:       disabled\n")
+		case i.tracker.observe(*m, now):
+			fmt.Fprintf(w, "  dedup:       new\n")
+		default:
+			fmt.Fprintf(w, "  dedup:       duplicate, would be dropped\n")
+			duplicates++
+			continue
+		}
+		emitted++
+	}
+
+	fmt.Fprintf(w, "\n%d rows, %d would be emitted, %d dropped, %d duplicates\n", len(rows), emitted, dropped, duplicates)
+	return nil
+}
+
+// writeMetricData writes the classification of a metric for the test report
+func writeMetricData(w io.Writer, m *MetricData) {
+	fmt.Fprintf(w, "  measurement: %s\n", m.Name)
+	fmt.Fprintf(w, "  time:        %s\n", m.Time.Format(time.RFC3339Nano))
+
+	keys := make([]string, 0, len(m.Tags))
+	for key := range m.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  tag:         %s = %q\n", key, m.Tags[key])
+	}
+
+	keys = keys[:0]
+	for key := range m.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "  field:       %s = %v (%T)\n", key, m.Fields[key], m.Fields[key])
+	}
+}
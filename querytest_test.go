@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestRunQueryTest tests the report of the test command
+func TestRunQueryTest(t *testing.T) {
+	response := `[
+		{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 42},
+		{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 42},
+		{"time": "2024-01-01T12:00:00Z", "host": "server2"}
+	]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/query_sql" {
+			t.Errorf("Unexpected request path %q", r.URL.Path)
+		}
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	plugin := &InfluxDBInput{
+		URL:                 server.URL,
+		Database:            "test",
+		Query:               "SELECT * FROM cpu",
+		TrackNewMetricsOnly: true,
+		Log:                 &simpleLogger{},
+	}
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := plugin.runQueryTest(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	report := buf.String()
+
+	for _, expected := range []string{
+		`"host": "server2"`,
+		`tag:         host = "server1"`,
+		"field:       value = 42 (float64)",
+		"dedup:       new",
+		"dedup:       duplicate, would be dropped",
+		"dropped:     no fields",
+		"3 rows, 1 would be emitted, 1 dropped, 1 duplicates",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("Expected report to contain %q, got:\n%s", expected, report)
+		}
+	}
+}
+
+// TestRunQueryTestError tests that query errors are returned
+func TestRunQueryTestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "database not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	plugin := &InfluxDBInput{URL: server.URL, Database: "test", Query: "SELECT * FROM cpu", Log: &simpleLogger{}}
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err := plugin.runQueryTest(&buf)
+	if err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("Expected error containing the response body, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// querySchedule computes the run times of a query executed every interval,
+// optionally aligned to wall-clock boundaries and delayed by a random jitter
+type querySchedule struct {
+	interval time.Duration
+	jitter   time.Duration
+	align    bool
+}
+
+// first returns the time of the first run, immediately or at the next
+// interval boundary if aligned
+func (s querySchedule) first(now time.Time) time.Time {
+	if s.align {
+		return now.Truncate(s.interval).Add(s.interval)
+	}
+	return now
+}
+
+// next returns the first scheduled time after prev that is not before now
+// and the number of runs missed because the scheduler fell behind, e.g.
+// while the host was suspended. Missed runs are skipped, so the schedule does
+// not drift.
+func (s querySchedule) next(prev, now time.Time) (time.Time, int) {
+	next := prev.Add(s.interval)
+	if !next.Before(now) {
+		return next, 0
+	}
+	missed := now.Sub(next)/s.interval + 1
+	return next.Add(missed * s.interval), int(missed)
+}
+
+// delay returns a random jitter to add to a scheduled time
+func (s querySchedule) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return rand.N(s.jitter)
+}
+
+// run calls fn in its own goroutine at every scheduled time until ctx is
+// done, reporting missed runs to skipped, and waits for the calls to return.
+// A call starts even if the previous one is still running, fn has to handle
+// the overlap.
+func (s querySchedule) run(ctx context.Context, fn func(ctx context.Context), skipped func(count int)) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scheduled := s.first(time.Now())
+	timer := time.NewTimer(time.Until(scheduled) + s.delay())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(ctx)
+		}()
+
+		var missed int
+		scheduled, missed = s.next(scheduled, time.Now())
+		if missed > 0 {
+			skipped(missed)
+		}
+		timer.Reset(time.Until(scheduled) + s.delay())
+	}
+}
+
+// skipMissedRuns counts and logs scheduled runs missed by the scheduler
+func (i *InfluxDBInput) skipMissedRuns(count int) {
+	i.skipCycles(count, "the scheduler fell behind")
+}
+
+// parseSchedule validates the query scheduling settings
+func (i *InfluxDBInput) parseSchedule() error {
+	var err error
+
+	i.queryInterval = 0
+	if i.QueryInterval != "" {
+		i.queryInterval, err = time.ParseDuration(i.QueryInterval)
+		if err != nil {
+			return fmt.Errorf("invalid query_interval: %w", err)
+		}
+		if i.queryInterval <= 0 {
+			return fmt.Errorf("query_interval must be positive, got %s", i.QueryInterval)
+		}
+	}
+
+	i.queryJitter = 0
+	if i.QueryJitter != "" {
+		i.queryJitter, err = time.ParseDuration(i.QueryJitter)
+		if err != nil {
+			return fmt.Errorf("invalid query_jitter: %w", err)
+		}
+		if i.queryJitter < 0 {
+			return fmt.Errorf("query_jitter must not be negative, got %s", i.QueryJitter)
+		}
+	}
+
+	return nil
+}
+
+// schedule returns the configured schedule for the given interval
+func (i *InfluxDBInput) schedule(interval time.Duration) querySchedule {
+	return querySchedule{interval: interval, jitter: i.queryJitter, align: i.QueryAlign}
+}
+
+// startScheduler runs the query on its own schedule, pushing the metrics into
+// acc, until Stop is called. Runs overlapping a running query are handled by
+// the overlap policy in gather.
+func (i *InfluxDBInput) startScheduler(acc telegraf.Accumulator) {
+	i.health.setInterval(i.queryInterval)
+
+	i.wg.Add(1)
+	go func() {
+		defer i.wg.Done()
+		i.schedule(i.queryInterval).run(i.ctx, func(ctx context.Context) {
+			// Report failed queries to Telegraf like those of Gather, except
+			// for queries cancelled by Stop
+			if err := i.gather(ctx, acc); err != nil && ctx.Err() == nil {
+				acc.AddError(err)
+			}
+		}, i.skipMissedRuns)
+	}()
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestQuerySchedule tests the run times of aligned and jittered schedules
+func TestQuerySchedule(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 7, 0, time.UTC)
+
+	s := querySchedule{interval: 10 * time.Second}
+	if first := s.first(now); !first.Equal(now) {
+		t.Errorf("Expected unaligned schedule to start immediately, got %v", first)
+	}
+
+	s.align = true
+	expected := time.Date(2024, 1, 1, 12, 0, 10, 0, time.UTC)
+	if first := s.first(now); !first.Equal(expected) {
+		t.Errorf("Expected aligned schedule to start at %v, got %v", expected, first)
+	}
+
+	// A run finishing in time is followed by the next one
+	if next, missed := s.next(expected, expected.Add(time.Second)); !next.Equal(expected.Add(10*time.Second)) || missed != 0 {
+		t.Errorf("Expected next run at %v, got %v with %d missed", expected.Add(10*time.Second), next, missed)
+	}
+
+	// Runs missed by a slow query are skipped without drifting
+	if next, missed := s.next(expected, expected.Add(25*time.Second)); !next.Equal(expected.Add(30*time.Second)) || missed != 2 {
+		t.Errorf("Expected next run at %v with 2 missed, got %v with %d missed", expected.Add(30*time.Second), next, missed)
+	}
+
+	s.jitter = 100 * time.Millisecond
+	for range 100 {
+		if delay := s.delay(); delay < 0 || delay >= s.jitter {
+			t.Fatalf("Expected jitter in [0, %v), got %v", s.jitter, delay)
+		}
+	}
+}
+
+// TestScheduler tests that a started plugin runs its query on its own schedule
+func TestScheduler(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}]`))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:     t.Name(),
+		QueryInterval: "10ms",
+		Log:           &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Start(acc); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for requests.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	plugin.Stop()
+
+	if count := requests.Load(); count < 3 {
+		t.Fatalf("Expected at least 3 scheduled queries, got %d", count)
+	}
+	// The last query may have been cancelled by Stop
+	if count := int64(len(acc.metrics)); count < requests.Load()-1 {
+		t.Errorf("Expected one metric per query, got %d metrics for %d queries", count, requests.Load())
+	}
+
+	// Telegraf's gathers do not query while scheduled
+	count := requests.Load()
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests.Load() != count {
+		t.Error("Expected Gather not to query with query_interval set")
+	}
+}
+
+// TestSchedulerReportsErrors tests that failed scheduled queries are reported
+// to the accumulator
+func TestSchedulerReportsErrors(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:     t.Name(),
+		QueryInterval: "10ms",
+		Log:           &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	var log strings.Builder
+	acc := &simpleAccumulator{log: &simpleLogger{output: &log}}
+	if err := plugin.Start(acc); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for requests.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	plugin.Stop()
+
+	if !strings.Contains(log.String(), "Accumulator error:") || !strings.Contains(log.String(), "503") {
+		t.Errorf("Expected failed queries to be reported, got %q", log.String())
+	}
+}
+
+// TestStopCancelsQuery tests that Stop aborts an in-flight query
+func TestStopCancelsQuery(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:     t.Name(),
+		QueryInterval: "1h",
+		Timeout:       "1m",
+		Log:           &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	if err := plugin.Start(&simpleAccumulator{}); err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the scheduled query")
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		plugin.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Stop to cancel the in-flight query")
+	}
+
+	if status := plugin.status(time.Now()); !strings.Contains(status.LastError, "context canceled") {
+		t.Errorf("Expected cancelled query error, got %q", status.LastError)
+	}
+}
+
+// TestScheduleValidation tests invalid scheduling settings
+func TestScheduleValidation(t *testing.T) {
+	invalid := []*InfluxDBInput{
+		{QueryInterval: "often"},
+		{QueryInterval: "-10s"},
+		{QueryJitter: "-1s"},
+	}
+
+	for idx, plugin := range invalid {
+		plugin.Log = &simpleLogger{}
+		if err := withConnection(plugin).Init(); err == nil {
+			t.Errorf("Expected error for invalid config %d", idx)
+		}
+	}
+}
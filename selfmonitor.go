@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// internalStats holds the self-monitoring counters of the plugin. They are
+// registered with Telegraf's selfstat, so inputs.internal reports them as the
+// internal_influxdb_input measurement when running in-process. Counters are
+// cumulative since startup, query_time_ns and the tracker values reflect the
+// last gather.
+type internalStats struct {
+	tags       map[string]string
+	key        string
+	registered bool
+
+	queryTime         selfstat.Stat
+	rowsReturned      selfstat.Stat
+	rowsDropped       selfstat.Stat
+	rowsFiltered      selfstat.Stat
+	metricsEmitted    selfstat.Stat
+	metricsSuppressed selfstat.Stat
+	httpErrors        selfstat.Stat
+	cyclesSkipped     selfstat.Stat
+	pushRequests      selfstat.Stat
+	pushErrors        selfstat.Stat
+	trackerEntries    selfstat.Stat
+	trackerMemory     selfstat.Stat
+	trackerEvictions  selfstat.Stat
+}
+
+// statsRefs counts the plugins using the counters of a tag set, guarded by
+// statsMu. Counters are shared by running plugins with identical tags.
+var (
+	statsMu   sync.Mutex
+	statsRefs = make(map[string]int)
+)
+
+// newInternalStats registers the self-monitoring counters for the given tags.
+// Plugins with identical tags share their counters while they run. Once the
+// last of them stopped, the next plugin starts counting from zero.
+func newInternalStats(tags map[string]string) *internalStats {
+	var sb strings.Builder
+	writeSortedTags(&sb, tags)
+	key := sb.String()
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	fresh := statsRefs[key] == 0
+	statsRefs[key]++
+	register := func(field string) selfstat.Stat {
+		if fresh {
+			selfstat.Unregister("influxdb_input", field, tags)
+		}
+		return selfstat.Register("influxdb_input", field, tags)
+	}
+
+	return &internalStats{
+		tags:              tags,
+		key:               key,
+		registered:        true,
+		queryTime:         register("query_time_ns"),
+		rowsReturned:      register("rows_returned"),
+		rowsDropped:       register("rows_dropped"),
+		rowsFiltered:      register("rows_filtered"),
+		metricsEmitted:    register("metrics_emitted"),
+		metricsSuppressed: register("metrics_suppressed"),
+		httpErrors:        register("http_errors"),
+		cyclesSkipped:     register("cycles_skipped"),
+		pushRequests:      register("push_requests"),
+		pushErrors:        register("push_errors"),
+		trackerEntries:    register("tracker_entries"),
+		trackerMemory:     register("tracker_memory_bytes"),
+		trackerEvictions:  register("tracker_evictions"),
+	}
+}
+
+// all returns all counters
+func (s *internalStats) all() []selfstat.Stat {
+	return []selfstat.Stat{
+		s.queryTime, s.rowsReturned, s.rowsDropped, s.rowsFiltered,
+		s.metricsEmitted, s.metricsSuppressed, s.httpErrors, s.cyclesSkipped,
+		s.pushRequests, s.pushErrors,
+		s.trackerEntries, s.trackerMemory, s.trackerEvictions,
+	}
+}
+
+// fields returns the current values of all counters
+func (s *internalStats) fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, stat := range s.all() {
+		fields[stat.FieldName()] = stat.Get()
+	}
+	return fields
+}
+
+// unregister releases the counters once the plugin stops, removing them from
+// selfstat when no other running plugin shares them
+func (s *internalStats) unregister() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	if !s.registered {
+		return
+	}
+	s.registered = false
+	statsRefs[s.key]--
+	if statsRefs[s.key] > 0 {
+		return
+	}
+	delete(statsRefs, s.key)
+	for _, stat := range s.all() {
+		stat.Unregister()
+	}
+}
+
+// reportInternalMetrics updates the tracker counters and, if enabled, emits
+// the influxdb_input_internal measurement
+func (i *InfluxDBInput) reportInternalMetrics(acc telegraf.Accumulator) {
+	if i.tracker != nil {
+		stats := i.tracker.stats()
+		i.stats.trackerEntries.Set(int64(stats.Entries))
+		i.stats.trackerMemory.Set(int64(stats.MemoryBytes))
+		i.stats.trackerEvictions.Set(int64(stats.Evictions))
+	}
+
+	if i.InternalMetrics {
+		acc.AddFields("influxdb_input_internal", i.stats.fields(), i.stats.tags, time.Now())
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+// findMetric returns the first metric with the given name
+func findMetric(metrics []telegraf.Metric, name string) telegraf.Metric {
+	for _, m := range metrics {
+		if m.Name() == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// TestInternalMetrics tests the self-monitoring counters emitted each gather
+func TestInternalMetrics(t *testing.T) {
+	response := `[
+		{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 42},
+		{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 42},
+		{"time": "2024-01-01T12:00:00Z", "host": "server2"},
+		{"time": "2024-01-01T12:00:00Z", "host": "server3", "value": -999}
+	]`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	plugin := &InfluxDBInput{
+		URL:                 server.URL,
+		Database:            "plant",
+		Query:               "SELECT * FROM sensors",
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		InternalMetrics:     true,
+		Transforms: []TransformRule{
+			{Columns: []string{"value"}, DropIfMatch: "^-999$"},
+		},
+		Log: &simpleLogger{},
+	}
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	before := plugin.stats.fields()
+
+	// The second gather only sees already propagated metrics
+	acc := &simpleAccumulator{}
+	for range 2 {
+		if err := plugin.Gather(acc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	internal := findMetric(acc.metrics[len(acc.metrics)-1:], "influxdb_input_internal")
+	if internal == nil {
+		t.Fatal("Expected influxdb_input_internal metric after the last gather")
+	}
+	if query, _ := internal.GetTag("query"); query != t.Name() {
+		t.Errorf("Expected query tag %q, got %q", t.Name(), query)
+	}
+	if database, _ := internal.GetTag("database"); database != "plant" {
+		t.Errorf("Expected database tag \"plant\", got %q", database)
+	}
+
+	expected := map[string]int64{
+		"rows_returned":      8,
+		"rows_dropped":       2,
+		"rows_filtered":      2,
+		"metrics_emitted":    1,
+		"metrics_suppressed": 3,
+		"http_errors":        0,
+		"tracker_entries":    1,
+	}
+	for field, value := range expected {
+		got, _ := internal.GetField(field)
+		if delta := got.(int64) - before[field].(int64); delta != value {
+			t.Errorf("Expected %s to change by %d, got %d", field, value, delta)
+		}
+	}
+	if got, _ := internal.GetField("query_time_ns"); got.(int64) <= 0 {
+		t.Errorf("Expected positive query_time_ns, got %v", got)
+	}
+
+	// The same counters are available to inputs.internal until the plugin stops
+	if findInternalStats(t.Name()) == nil {
+		t.Error("Expected counters to be registered with selfstat")
+	}
+	plugin.Stop()
+	if findInternalStats(t.Name()) != nil {
+		t.Error("Expected counters to be unregistered after Stop")
+	}
+}
+
+// TestInternalMetricsSharedTags tests that running plugins with identical
+// tags keep their counters until the last one stops, and that a plugin
+// started afterwards, e.g. on a config reload, counts from zero
+func TestInternalMetricsSharedTags(t *testing.T) {
+	first := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	second := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	if err := first.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	first.stats.httpErrors.Incr(3)
+	if err := second.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	second.stats.httpErrors.Incr(1)
+	if got := first.stats.httpErrors.Get(); got != 4 {
+		t.Errorf("Expected shared http_errors of 4, got %d", got)
+	}
+
+	first.Stop()
+	first.Stop()
+	if m := findInternalStats(t.Name()); m == nil {
+		t.Fatal("Expected counters of the running plugin to stay registered")
+	} else if got, _ := m.GetField("http_errors"); got != int64(4) {
+		t.Errorf("Expected http_errors of 4 after the first plugin stopped, got %v", got)
+	}
+
+	second.Stop()
+	if findInternalStats(t.Name()) != nil {
+		t.Error("Expected counters to be unregistered after both plugins stopped")
+	}
+
+	reloaded := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	if err := reloaded.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	t.Cleanup(reloaded.Stop)
+	if got := reloaded.stats.httpErrors.Get(); got != 0 {
+		t.Errorf("Expected fresh http_errors counter, got %d", got)
+	}
+}
+
+// findInternalStats returns the selfstat metric of the given query
+func findInternalStats(query string) telegraf.Metric {
+	for _, m := range selfstat.Metrics() {
+		if tag, _ := m.GetTag("query"); m.Name() == "internal_influxdb_input" && tag == query {
+			return m
+		}
+	}
+	return nil
+}
+
+// TestInternalMetricsHTTPErrors tests that failed queries are counted and reported
+func TestInternalMetricsHTTPErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:       t.Name(),
+		InternalMetrics: true,
+		Log:             &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	before := plugin.stats.fields()
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Gather(acc); err == nil {
+		t.Fatal("Expected error for failed query")
+	}
+
+	internal := findMetric(acc.metrics, "influxdb_input_internal")
+	if internal == nil {
+		t.Fatal("Expected influxdb_input_internal metric for failed gather")
+	}
+	if got, _ := internal.GetField("http_errors"); got.(int64)-before["http_errors"].(int64) != 1 {
+		t.Errorf("Expected http_errors to change by 1, got %v", got)
+	}
+}
+
+// TestInternalMetricsDisabled tests that no measurement is emitted by default
+func TestInternalMetricsDisabled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"host": "server1", "value": 1}]`))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{QueryName: t.Name(), Log: &simpleLogger{}})
+	plugin.URL = server.URL
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	if err := plugin.Gather(acc); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if findMetric(acc.metrics, "influxdb_input_internal") != nil {
+		t.Error("Expected no influxdb_input_internal metric when disabled")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+)
+
+// spoolSegmentSize is the size at which the spool starts a new segment file
+const spoolSegmentSize = 8 << 20
+
+// spoolOutput stores the metrics on disk before handing them to the next
+// output, so metrics gathered while the destination is unreachable survive
+// until it recovers, even across restarts. Metrics are appended to segment
+// files as line protocol and accepted once synced to disk. Segments are
+// replayed in order and deleted once written, the oldest are dropped when
+// the spool exceeds maxSize or maxAge. It is not safe for concurrent use.
+type spoolOutput struct {
+	dir         string
+	maxSize     int64
+	maxAge      time.Duration // Zero keeps segments regardless of their age
+	segmentSize int64
+	next        metricOutput
+	serializer  *lineProtocolSerializer
+	parser      *influx.Parser
+	log         telegraf.Logger
+
+	current     *os.File // Segment being appended to
+	currentSize int64
+	seq         uint64 // Sequence number of the newest segment
+	backlog     bool   // Segments are waiting for the destination
+}
+
+// spoolSegment is a segment file of the spool
+type spoolSegment struct {
+	path    string
+	seq     uint64
+	size    int64
+	modTime time.Time
+}
+
+// newSpoolOutput validates the settings and opens the spool in dir, creating
+// the directory if needed. Segments left by a previous run are replayed by
+// the next write.
+func newSpoolOutput(dir string, maxSize int64, maxAge time.Duration, next metricOutput, log telegraf.Logger) (*spoolOutput, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("spool max size must be positive, got %d", maxSize)
+	}
+	if maxAge < 0 {
+		return nil, fmt.Errorf("spool max age must not be negative, got %s", maxAge)
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	parser := &influx.Parser{}
+	if err := parser.Init(); err != nil {
+		return nil, fmt.Errorf("failed to initialize spool parser: %w", err)
+	}
+
+	o := &spoolOutput{
+		dir:         dir,
+		maxSize:     maxSize,
+		maxAge:      maxAge,
+		segmentSize: max(min(spoolSegmentSize, maxSize/4), 1),
+		next:        next,
+		serializer:  &lineProtocolSerializer{},
+		parser:      parser,
+		log:         log,
+	}
+
+	segments, err := o.segments()
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		var size int64
+		for _, seg := range segments {
+			size += seg.size
+		}
+		o.seq = segments[len(segments)-1].seq
+		o.backlog = true
+		o.log.Infof("Spool holds %d segment(s) with %d bytes from a previous run, replaying", len(segments), size)
+	}
+
+	return o, nil
+}
+
+// Write spools the metrics and replays the spool to the next output. Only a
+// failure to spool is returned, failed replays are logged and retried by the
+// next write.
+func (o *spoolOutput) Write(ctx context.Context, metrics []telegraf.Metric) error {
+	if err := o.append(metrics); err != nil {
+		return err
+	}
+	if err := o.enforceLimits(time.Now()); err != nil {
+		return err
+	}
+	return o.replay(ctx)
+}
+
+// append writes the metrics to the current segment and syncs it to disk.
+// Spooled metrics are accepted, as their delivery is now up to the spool.
+func (o *spoolOutput) append(metrics []telegraf.Metric) error {
+	var buf bytes.Buffer
+	spooled := make([]telegraf.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		line, err := o.serializer.Serialize(m)
+		if err != nil {
+			o.log.Warnf("Skipping metric: %v", err)
+			m.Drop()
+			continue
+		}
+		buf.Write(line)
+		spooled = append(spooled, m)
+	}
+	if len(spooled) == 0 {
+		return nil
+	}
+
+	err := o.appendSegment(buf.Bytes())
+	for _, m := range spooled {
+		if err != nil {
+			m.Reject()
+		} else {
+			m.Accept()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to spool metrics: %w", err)
+	}
+	return nil
+}
+
+// appendSegment appends data to the current segment, starting a new one if
+// there is none or the current one is full
+func (o *spoolOutput) appendSegment(data []byte) error {
+	if o.current != nil && o.currentSize >= o.segmentSize {
+		if err := o.closeSegment(); err != nil {
+			return err
+		}
+	}
+	if o.current == nil {
+		o.seq++
+		f, err := os.OpenFile(o.segmentPath(o.seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		o.current, o.currentSize = f, 0
+	}
+
+	if _, err := o.current.Write(data); err != nil {
+		o.rollback()
+		return err
+	}
+	if err := o.current.Sync(); err != nil {
+		o.rollback()
+		return err
+	}
+	o.currentSize += int64(len(data))
+	return nil
+}
+
+// rollback removes what a failed append left in the current segment, e.g.
+// part of a line when the disk is full, as the metrics are rejected and
+// would otherwise be replayed twice or corrupt the segment. If the segment
+// cannot be truncated it is closed, so the next append starts a new one.
+func (o *spoolOutput) rollback() {
+	if err := o.current.Truncate(o.currentSize); err != nil {
+		o.log.Warnf("Failed to truncate spool segment %s, starting a new one: %v", o.current.Name(), err)
+		o.closeSegment()
+	}
+}
+
+// closeSegment closes the current segment, the next append starts a new one
+func (o *spoolOutput) closeSegment() error {
+	if o.current == nil {
+		return nil
+	}
+	err := o.current.Close()
+	o.current = nil
+	return err
+}
+
+// enforceLimits drops the oldest segments while the spool is larger than
+// maxSize and segments older than maxAge
+func (o *spoolOutput) enforceLimits(now time.Time) error {
+	segments, err := o.segments()
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, seg := range segments {
+		total += seg.size
+	}
+
+	for _, seg := range segments {
+		var reason string
+		switch {
+		case total > o.maxSize:
+			reason = fmt.Sprintf("spool larger than %d bytes", o.maxSize)
+		case o.maxAge > 0 && now.Sub(seg.modTime) > o.maxAge:
+			reason = fmt.Sprintf("older than %s", o.maxAge)
+		default:
+			continue
+		}
+
+		if err := o.removeSegment(seg); err != nil {
+			return err
+		}
+		total -= seg.size
+		o.log.Warnf("Dropped spooled metrics of segment %s (%d bytes): %s", filepath.Base(seg.path), seg.size, reason)
+	}
+	return nil
+}
+
+// replay writes the segments to the next output in order, deleting each once
+// written. It stops at the first failure, leaving the segment for the next
+// attempt. A segment written partially before the failure is written again
+// in full, which InfluxDB deduplicates as the points are identical.
+func (o *spoolOutput) replay(ctx context.Context) error {
+	segments, err := o.segments()
+	if err != nil {
+		return err
+	}
+
+	var replayed int
+	for idx, seg := range segments {
+		metrics, err := o.readSegment(seg)
+		if err != nil {
+			return err
+		}
+		if err := o.next.Write(ctx, metrics); err != nil {
+			var pending int64
+			for _, s := range segments[idx:] {
+				pending += s.size
+			}
+			o.log.Warnf("Failed to write metrics, %d segment(s) with %d bytes spooled for replay: %v", len(segments)-idx, pending, err)
+			o.backlog = true
+			return nil
+		}
+		if err := o.removeSegment(seg); err != nil {
+			return err
+		}
+		replayed += len(metrics)
+	}
+
+	if o.backlog {
+		o.log.Infof("Spool replayed, %d metrics written", replayed)
+		o.backlog = false
+	}
+	return nil
+}
+
+// readSegment parses the metrics of a segment. A segment cut short by a
+// crash ends in a partial line, which is dropped with the rest of the segment.
+func (o *spoolOutput) readSegment(seg spoolSegment) ([]telegraf.Metric, error) {
+	// The current segment is complete once replayed, later appends go to a
+	// new one
+	if o.current != nil && seg.seq == o.seq {
+		if err := o.closeSegment(); err != nil {
+			return nil, fmt.Errorf("failed to close spool segment: %w", err)
+		}
+	}
+
+	data, err := os.ReadFile(seg.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	// A line without its newline was torn by a crash, even if it parses
+	if end := bytes.LastIndexByte(data, '\n') + 1; end < len(data) {
+		o.log.Warnf("Spool segment %s ends in a partial line, dropping %d bytes: %q", filepath.Base(seg.path), len(data)-end, data[end:])
+		data = data[:end]
+	}
+
+	metrics, err := o.parser.Parse(data)
+	var perr *influx.ParseError
+	if errors.As(err, &perr) {
+		o.log.Warnf("Spool segment %s is corrupt from line %d on, dropping the rest: %v", filepath.Base(seg.path), perr.LineNumber, err)
+		metrics, err = o.parser.Parse(data[:perr.LineOffset])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse spool segment %s: %w", filepath.Base(seg.path), err)
+	}
+	return metrics, nil
+}
+
+// removeSegment deletes a segment, closing it first if it is the current one
+func (o *spoolOutput) removeSegment(seg spoolSegment) error {
+	if o.current != nil && seg.seq == o.seq {
+		if err := o.closeSegment(); err != nil {
+			return fmt.Errorf("failed to close spool segment: %w", err)
+		}
+	}
+	if err := os.Remove(seg.path); err != nil {
+		return fmt.Errorf("failed to remove spool segment: %w", err)
+	}
+	return nil
+}
+
+// segments lists the segment files of the spool, oldest first
+func (o *spoolOutput) segments() ([]spoolSegment, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var segments []spoolSegment
+	for _, entry := range entries {
+		var seq uint64
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".lp") {
+			continue
+		}
+		if _, err := fmt.Sscanf(entry.Name(), "segment-%d.lp", &seq); err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool segment: %w", err)
+		}
+		segments = append(segments, spoolSegment{
+			path:    filepath.Join(o.dir, entry.Name()),
+			seq:     seq,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(segments, func(a, b int) bool { return segments[a].seq < segments[b].seq })
+
+	return segments, nil
+}
+
+// segmentPath returns the path of the segment with the given sequence number
+func (o *spoolOutput) segmentPath(seq uint64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("segment-%020d.lp", seq))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// fakeOutput records the metrics written to it and fails while down is set
+type fakeOutput struct {
+	down    bool
+	written []time.Time
+}
+
+func (o *fakeOutput) Write(_ context.Context, metrics []telegraf.Metric) error {
+	if o.down {
+		return errors.New("connection refused")
+	}
+	for _, m := range metrics {
+		o.written = append(o.written, m.Time())
+	}
+	return nil
+}
+
+// newTestSpool opens a spool in dir writing to next
+func newTestSpool(t *testing.T, dir string, maxSize int64, next metricOutput) *spoolOutput {
+	t.Helper()
+	spool, err := newSpoolOutput(dir, maxSize, 0, next, &simpleLogger{})
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	return spool
+}
+
+// spoolFiles lists the segment files in dir
+func spoolFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "segment-*.lp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestSpoolReplaysInOrder tests that metrics spooled while the destination is
+// down are written in order once it recovers, also after a restart
+func TestSpoolReplaysInOrder(t *testing.T) {
+	dir := t.TempDir()
+	next := &fakeOutput{down: true}
+	spool := newTestSpool(t, dir, 1<<20, next)
+
+	metrics := writerMetrics(6)
+	acc := &simpleAccumulator{}
+	tracking := acc.WithTracking(1)
+	tracking.AddTrackingMetricGroup(metrics[:2])
+	if err := spool.Write(context.Background(), acc.metrics); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if info := <-tracking.Delivered(); !info.Delivered() {
+		t.Error("Expected spooled metrics to be accepted")
+	}
+	if err := spool.Write(context.Background(), metrics[2:4]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(next.written) != 0 {
+		t.Fatalf("Expected no metrics written while down, got %d", len(next.written))
+	}
+
+	// Restart and recover
+	spool = newTestSpool(t, dir, 1<<20, next)
+	next.down = false
+	if err := spool.Write(context.Background(), metrics[4:]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(next.written) != len(metrics) {
+		t.Fatalf("Expected %d metrics written, got %d", len(metrics), len(next.written))
+	}
+	for idx, m := range metrics {
+		if !next.written[idx].Equal(m.Time()) {
+			t.Errorf("Expected metric %d at %s, got %s", idx, m.Time(), next.written[idx])
+		}
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected empty spool, got %v", files)
+	}
+}
+
+// TestSpoolLimits tests that the oldest segments are dropped beyond the size
+// and age limits
+func TestSpoolLimits(t *testing.T) {
+	dir := t.TempDir()
+	next := &fakeOutput{down: true}
+
+	line, err := (&lineProtocolSerializer{}).Serialize(writerMetrics(1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every segment holds a single metric
+	spool := newTestSpool(t, dir, int64(3*len(line)), next)
+	spool.segmentSize = 1
+
+	metrics := writerMetrics(5)
+	for _, m := range metrics {
+		if err := spool.Write(context.Background(), []telegraf.Metric{m}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if files := spoolFiles(t, dir); len(files) != 3 {
+		t.Fatalf("Expected 3 segments, got %v", files)
+	}
+
+	// Age out the oldest remaining segment
+	files := spoolFiles(t, dir)
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(files[0], old, old); err != nil {
+		t.Fatal(err)
+	}
+	spool.maxAge = time.Hour
+
+	next.down = false
+	if err := spool.Write(context.Background(), nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(next.written) != 2 || !next.written[0].Equal(metrics[3].Time()) || !next.written[1].Equal(metrics[4].Time()) {
+		t.Errorf("Expected the newest 2 metrics, got %v", next.written)
+	}
+}
+
+// TestSpoolTruncatedSegment tests that a segment cut short by a crash is
+// replayed up to the partial line
+func TestSpoolTruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	data := "cpu,host=server1 usage=1.5 1704110400000000000\ncpu,host=server1 usage=2.5 1704110401000000000\ncpu,host=ser"
+	if err := os.WriteFile(filepath.Join(dir, "segment-00000000000000000007.lp"), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var log strings.Builder
+	next := &fakeOutput{}
+	spool, err := newSpoolOutput(dir, 1<<20, 0, next, &simpleLogger{output: &log})
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	if err := spool.Write(context.Background(), writerMetrics(1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(next.written) != 3 {
+		t.Errorf("Expected 2 replayed and 1 new metric, got %d", len(next.written))
+	}
+	if !strings.Contains(log.String(), "ends in a partial line, dropping 12 bytes") {
+		t.Errorf("Expected partial line in log, got %q", log.String())
+	}
+	if files := spoolFiles(t, dir); len(files) != 0 {
+		t.Errorf("Expected empty spool, got %v", files)
+	}
+}
+
+// TestSpoolTornLine tests that a partial line is dropped even if it parses,
+// e.g. a value cut short by a crash
+func TestSpoolTornLine(t *testing.T) {
+	dir := t.TempDir()
+	data := "cpu,host=a usage=1.5 1704110400000000000\ncpu,host=a usage=12"
+	if err := os.WriteFile(filepath.Join(dir, "segment-00000000000000000007.lp"), []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var log strings.Builder
+	next := &fakeOutput{}
+	spool, err := newSpoolOutput(dir, 1<<20, 0, next, &simpleLogger{output: &log})
+	if err != nil {
+		t.Fatalf("Failed to open spool: %v", err)
+	}
+	if err := spool.Write(context.Background(), writerMetrics(1)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(next.written) != 2 || !next.written[0].Equal(time.Unix(1704110400, 0)) {
+		t.Errorf("Expected 1 replayed and 1 new metric, got %v", next.written)
+	}
+	if !strings.Contains(log.String(), "dropping 19 bytes") {
+		t.Errorf("Expected torn line in log, got %q", log.String())
+	}
+}
+
+// TestSpoolRollback tests that a failed append leaves no partial line behind
+func TestSpoolRollback(t *testing.T) {
+	dir := t.TempDir()
+	spool := newTestSpool(t, dir, 1<<20, &fakeOutput{down: true})
+
+	first, second := "cpu usage=1 1704110400000000000\n", "cpu usage=2 1704110401000000000\n"
+	if err := spool.appendSegment([]byte(first)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// A write cut short by a full disk
+	if _, err := spool.current.Write([]byte("cpu,host=ser")); err != nil {
+		t.Fatal(err)
+	}
+	spool.rollback()
+	if err := spool.appendSegment([]byte(second)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	path := spool.current.Name()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != first+second {
+		t.Errorf("Expected segment:\n%s\ngot:\n%s", first+second, data)
+	}
+
+	// Without a writable segment the next append starts a new one
+	spool.closeSegment()
+	if spool.current, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := spool.appendSegment([]byte(first)); err == nil {
+		t.Fatal("Expected error writing to a read-only segment")
+	}
+	if spool.current != nil {
+		t.Error("Expected the segment to be closed")
+	}
+	if err := spool.appendSegment([]byte(first)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if files := spoolFiles(t, dir); len(files) != 2 {
+		t.Errorf("Expected a new segment, got %v", files)
+	}
+}
+
+// TestSpoolOptions tests invalid spool settings
+func TestSpoolOptions(t *testing.T) {
+	if _, err := newSpoolOutput(t.TempDir(), 0, 0, &fakeOutput{}, &simpleLogger{}); err == nil {
+		t.Error("Expected error for zero max size")
+	}
+	if _, err := newSpoolOutput(t.TempDir(), 1<<20, -time.Hour, &fakeOutput{}, &simpleLogger{}); err == nil {
+		t.Error("Expected error for negative max age")
+	}
+}
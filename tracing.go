@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the plugin's spans
+const tracerName = "github.com/KilianSen/Telegraf-InfluxDB-Input"
+
+// initTracing sets up the tracer for the configured exporter. Without an
+// exporter a no-op tracer is used. The tracer provider is private to the
+// plugin so it does not interfere with Telegraf's own telemetry.
+func (i *InfluxDBInput) initTracing() error {
+	if i.TracingSampleRatio == 0 {
+		i.TracingSampleRatio = 1
+	}
+	if i.TracingSampleRatio < 0 || i.TracingSampleRatio > 1 {
+		return fmt.Errorf("tracing_sample_ratio must be between 0 and 1, got %v", i.TracingSampleRatio)
+	}
+	if i.TracingServiceName == "" {
+		i.TracingServiceName = "telegraf-influxdb-input"
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch i.TracingExporter {
+	case "", "none":
+		i.tracer = noop.NewTracerProvider().Tracer(tracerName)
+		return nil
+	case "otlp_grpc":
+		var opts []otlptracegrpc.Option
+		if i.TracingEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(i.TracingEndpoint))
+		}
+		if i.TracingInsecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), opts...)
+	case "otlp_http":
+		var opts []otlptracehttp.Option
+		if i.TracingEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(i.TracingEndpoint))
+		}
+		if i.TracingInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return fmt.Errorf("invalid tracing_exporter %q, expected \"none\", \"otlp_grpc\" or \"otlp_http\"", i.TracingExporter)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s trace exporter: %w", i.TracingExporter, err)
+	}
+
+	i.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(i.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", i.TracingServiceName))),
+	)
+	i.tracer = i.tracerProvider.Tracer(tracerName)
+
+	return nil
+}
+
+// shutdownTracing flushes pending spans and stops the exporter
+func (i *InfluxDBInput) shutdownTracing(ctx context.Context) {
+	if i.tracerProvider == nil {
+		return
+	}
+
+	if err := i.tracerProvider.Shutdown(ctx); err != nil {
+		i.Log.Errorf("Failed to shut down tracing: %v", err)
+	}
+	i.tracerProvider = nil
+}
+
+// startSpan starts a span for a phase of the gather, tagged with the query
+func (i *InfluxDBInput) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("influxdb_input.query", i.QueryName),
+		attribute.String("db.namespace", i.Database),
+	)
+	return i.tracer.Start(ctx, "influxdb_input."+name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTracedPlugin creates an initialized plugin recording its spans
+func newTracedPlugin(t *testing.T, url string) (*InfluxDBInput, *tracetest.SpanRecorder) {
+	plugin := withConnection(&InfluxDBInput{
+		TrackNewMetricsOnly: true,
+		Log:                 &simpleLogger{},
+	})
+	plugin.URL = url
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	plugin.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+	return plugin, recorder
+}
+
+// spanAttribute returns the value of an attribute of a recorded span
+func spanAttribute(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, attr := range span.Attributes() {
+		if string(attr.Key) == key {
+			return attr.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+// TestTracingSpans tests the spans of a gather and the propagated trace context
+func TestTracingSpans(t *testing.T) {
+	var traceparent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte(`[{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}, {"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}, {"host": "server2"}]`))
+	}))
+	defer server.Close()
+
+	plugin, recorder := newTracedPlugin(t, server.URL)
+	if err := plugin.Gather(&simpleAccumulator{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	gather, ok := spans["influxdb_input.gather"]
+	if !ok {
+		t.Fatalf("Expected gather span, got %v", recorder.Ended())
+	}
+
+	for _, name := range []string{"influxdb_input.http_query", "influxdb_input.decode", "influxdb_input.dedup"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != gather.SpanContext().SpanID() {
+			t.Errorf("Expected %s to be a child of the gather span", name)
+		}
+	}
+
+	query := spans["influxdb_input.http_query"]
+	if code := spanAttribute(query, "http.response.status_code").AsInt64(); code != http.StatusOK {
+		t.Errorf("Expected status code attribute 200, got %d", code)
+	}
+	if !strings.Contains(traceparent, query.SpanContext().SpanID().String()) {
+		t.Errorf("Expected traceparent %q to carry the query span", traceparent)
+	}
+
+	decode := spans["influxdb_input.decode"]
+	if rows := spanAttribute(decode, "influxdb_input.rows_returned").AsInt64(); rows != 3 {
+		t.Errorf("Expected 3 returned rows, got %d", rows)
+	}
+	if rows := spanAttribute(decode, "influxdb_input.rows_dropped").AsInt64(); rows != 1 {
+		t.Errorf("Expected 1 dropped row, got %d", rows)
+	}
+
+	dedup := spans["influxdb_input.dedup"]
+	if suppressed := spanAttribute(dedup, "influxdb_input.metrics_suppressed").AsInt64(); suppressed != 1 {
+		t.Errorf("Expected 1 suppressed metric, got %d", suppressed)
+	}
+}
+
+// TestTracingErrors tests that failed queries mark the spans as errors
+func TestTracingErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	plugin, recorder := newTracedPlugin(t, server.URL)
+	if err := plugin.Gather(&simpleAccumulator{}); err == nil {
+		t.Fatal("Expected error for failed query")
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Status().Code != codes.Error {
+			t.Errorf("Expected span %s to have error status, got %v", span.Name(), span.Status())
+		}
+		if span.Name() == "influxdb_input.http_query" {
+			if code := spanAttribute(span, "http.response.status_code").AsInt64(); code != http.StatusServiceUnavailable {
+				t.Errorf("Expected status code attribute 503, got %d", code)
+			}
+		}
+	}
+	if len(recorder.Ended()) != 2 {
+		t.Errorf("Expected gather and query spans, got %d spans", len(recorder.Ended()))
+	}
+}
+
+// TestTracingValidation tests invalid tracing settings
+func TestTracingValidation(t *testing.T) {
+	invalid := []*InfluxDBInput{
+		{TracingExporter: "zipkin"},
+		{TracingExporter: "otlp_grpc", TracingSampleRatio: 2},
+	}
+
+	for idx, plugin := range invalid {
+		plugin.Log = &simpleLogger{}
+		if err := withConnection(plugin).Init(); err == nil {
+			t.Errorf("Expected error for invalid config %d", idx)
+		}
+	}
+
+	// Exporters connect lazily, so creating one does not need a collector
+	plugin := withConnection(&InfluxDBInput{TracingExporter: "otlp_http", TracingInsecure: true, Log: &simpleLogger{}})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Unexpected error creating exporter: %v", err)
+	}
+	plugin.Stop()
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// dedupTracker is implemented by the deduplication strategies
+type dedupTracker interface {
+	// isNew reports whether m has not been propagated yet
+	isNew(m MetricData) bool
+	// markSeen records m as propagated at the given wall clock time
+	markSeen(m MetricData, now time.Time)
+	// observe atomically checks whether m is new and records it if so
+	observe(m MetricData, now time.Time) bool
+	// removeOlderThan expires entries last updated before cutoff and returns
+	// the number of removed entries
+	removeOlderThan(cutoff time.Time) int
+	// stats returns a summary of the tracker state
+	stats() trackerStats
+}
+
+// trackerStats summarizes the state of a dedup tracker
+type trackerStats struct {
+	Entries     int
+	Evictions   uint64
+	MemoryBytes int
+
+	// Only reported by approximate trackers
+	FillRatio         float64
+	FalsePositiveRate float64
+}
+
+// shardIndex selects the shard for key out of count shards
+func shardIndex(key string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(count))
+}
+
+// minShardSize is the smallest size limit of a shard. Smaller shards would
+// evict keys long before the overall limit is reached, as keys are not spread
+// exactly evenly.
+const minShardSize = 1024
+
+// shardCount limits the number of shards so that each holds at least
+// minShardSize keys, 0 meaning unlimited
+func shardCount(maxSize, count int) int {
+	if maxSize <= 0 {
+		return count
+	}
+	return max(min(count, maxSize/minShardSize), 1)
+}
+
+// shardSize splits a size limit across count shards, 0 meaning unlimited
+func shardSize(maxSize, count int) int {
+	if maxSize <= 0 {
+		return 0
+	}
+	return (maxSize + count - 1) / count
+}
+
+// exactTracker deduplicates metrics by remembering the key of every
+// propagated point. Keys are spread over independently locked shards so
+// concurrent queries and cleanups do not serialize on a single mutex; the
+// size limit and LRU eviction apply per shard.
+type exactTracker struct {
+	shards         []*metricTracker
+	key            func(MetricData) string
+	reemitOnChange bool
+}
+
+// newExactTracker creates an exact tracker holding about maxSize keys
+// generated by key, spread over the given number of shards
+func newExactTracker(maxSize, shards int, key func(MetricData) string, reemitOnChange bool) *exactTracker {
+	shards = shardCount(maxSize, shards)
+	t := &exactTracker{
+		shards:         make([]*metricTracker, shards),
+		key:            key,
+		reemitOnChange: reemitOnChange,
+	}
+	for idx := range t.shards {
+		t.shards[idx] = newMetricTracker(shardSize(maxSize, shards))
+	}
+
+	return t
+}
+
+// shard returns the shard responsible for key
+func (t *exactTracker) shard(key string) *metricTracker {
+	return t.shards[shardIndex(key, len(t.shards))]
+}
+
+// lookup returns the field hash stored for key and whether key is tracked
+func (t *exactTracker) lookup(key string) (uint64, bool) {
+	return t.shard(key).lookup(key)
+}
+
+// add records key as seen at the given time
+func (t *exactTracker) add(key string, hash uint64, now time.Time) {
+	t.shard(key).add(key, hash, now)
+}
+
+// isNew checks if a metric has been seen before
+func (t *exactTracker) isNew(m MetricData) bool {
+	hash, exists := t.lookup(t.key(m))
+
+	// A known point is re-emitted when its field values changed
+	if exists && t.reemitOnChange {
+		return hash != hashFields(m.Fields)
+	}
+
+	return !exists
+}
+
+// markSeen adds a metric to the tracked keys
+func (t *exactTracker) markSeen(m MetricData, now time.Time) {
+	var hash uint64
+	if t.reemitOnChange {
+		hash = hashFields(m.Fields)
+	}
+
+	t.add(t.key(m), hash, now)
+}
+
+// observe atomically checks and records a metric
+func (t *exactTracker) observe(m MetricData, now time.Time) bool {
+	var hash uint64
+	if t.reemitOnChange {
+		hash = hashFields(m.Fields)
+	}
+
+	key := t.key(m)
+	return t.shard(key).addIfNew(key, hash, t.reemitOnChange, now)
+}
+
+// removeOlderThan expires old keys shard by shard
+func (t *exactTracker) removeOlderThan(cutoff time.Time) int {
+	removed := 0
+	for _, shard := range t.shards {
+		removed += shard.removeOlderThan(cutoff)
+	}
+	return removed
+}
+
+// stats returns a summary of the tracker state
+func (t *exactTracker) stats() trackerStats {
+	var stats trackerStats
+	for _, shard := range t.shards {
+		stats.Entries += shard.len()
+		stats.Evictions += shard.evicted()
+		stats.MemoryBytes += shard.memoryUsage()
+	}
+	return stats
+}
+
+// trackedMetricOverhead is the approximate number of bytes used per tracked
+// entry in addition to the key itself: the map slot, the list element and the
+// trackedMetric struct
+const trackedMetricOverhead = 128
+
+// metricTracker remembers the keys of already propagated metrics. Entries are
+// kept in a doubly linked list ordered by the time they were last seen, so
+// lookup, insertion, expiry and eviction of the oldest entry are all O(1).
+type metricTracker struct {
+	mu        sync.RWMutex
+	maxSize   int
+	entries   map[string]*list.Element
+	order     *list.List // Front is the least recently seen entry
+	keyBytes  int
+	evictions uint64
+}
+
+// trackedMetric is a single entry of the tracker
+type trackedMetric struct {
+	key  string
+	seen time.Time
+	hash uint64
+}
+
+// newMetricTracker creates a tracker holding at most maxSize entries
+func newMetricTracker(maxSize int) *metricTracker {
+	return &metricTracker{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// lookup returns the field hash stored for key and whether key is tracked
+func (t *metricTracker) lookup(key string) (uint64, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	elem, exists := t.entries[key]
+	if !exists {
+		return 0, false
+	}
+	return elem.Value.(*trackedMetric).hash, true
+}
+
+// add records key as seen at the given time. Already tracked keys are
+// refreshed and moved to the back, and the least recently seen entry is
+// evicted once the size limit is exceeded. Times are expected to be
+// non-decreasing between calls.
+func (t *metricTracker) add(key string, hash uint64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.set(key, hash, now)
+}
+
+// addIfNew atomically checks and records key. It returns true and records
+// key if it is not tracked yet, or if compareHash is set and the stored hash
+// differs from hash.
+func (t *metricTracker) addIfNew(key string, hash uint64, compareHash bool, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if elem, exists := t.entries[key]; exists {
+		if !compareHash || elem.Value.(*trackedMetric).hash == hash {
+			return false
+		}
+	}
+
+	t.set(key, hash, now)
+	return true
+}
+
+// set records key, the caller must hold the write lock
+func (t *metricTracker) set(key string, hash uint64, now time.Time) {
+	if elem, exists := t.entries[key]; exists {
+		entry := elem.Value.(*trackedMetric)
+		entry.seen = now
+		entry.hash = hash
+		t.order.MoveToBack(elem)
+		return
+	}
+
+	t.entries[key] = t.order.PushBack(&trackedMetric{key: key, seen: now, hash: hash})
+	t.keyBytes += len(key)
+
+	for t.maxSize > 0 && t.order.Len() > t.maxSize {
+		t.removeElement(t.order.Front())
+		t.evictions++
+	}
+}
+
+// removeOlderThan drops all entries last seen before cutoff and returns the
+// number of removed entries
+func (t *metricTracker) removeOlderThan(cutoff time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	removed := 0
+	for elem := t.order.Front(); elem != nil; elem = t.order.Front() {
+		if !elem.Value.(*trackedMetric).seen.Before(cutoff) {
+			break
+		}
+		t.removeElement(elem)
+		removed++
+	}
+
+	return removed
+}
+
+// removeElement unlinks an entry, the caller must hold the write lock
+func (t *metricTracker) removeElement(elem *list.Element) {
+	key := elem.Value.(*trackedMetric).key
+	t.order.Remove(elem)
+	delete(t.entries, key)
+	t.keyBytes -= len(key)
+}
+
+// len returns the number of tracked entries
+func (t *metricTracker) len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.order.Len()
+}
+
+// evicted returns the total number of entries evicted due to the size limit
+func (t *metricTracker) evicted() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.evictions
+}
+
+// memoryUsage returns the approximate memory used by the tracked entries in bytes
+func (t *metricTracker) memoryUsage() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.order.Len()*trackedMetricOverhead + t.keyBytes
+}
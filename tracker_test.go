@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMetricTrackerRefresh tests that re-adding a key protects it from eviction
+func TestMetricTrackerRefresh(t *testing.T) {
+	tracker := newMetricTracker(2)
+	now := time.Now()
+
+	tracker.add("a", 0, now)
+	tracker.add("b", 0, now.Add(time.Second))
+	tracker.add("a", 1, now.Add(2*time.Second))
+	tracker.add("c", 0, now.Add(3*time.Second))
+
+	if _, exists := tracker.lookup("b"); exists {
+		t.Error("Expected least recently seen key 'b' to be evicted")
+	}
+
+	hash, exists := tracker.lookup("a")
+	if !exists {
+		t.Fatal("Expected refreshed key 'a' to still be tracked")
+	}
+	if hash != 1 {
+		t.Errorf("Expected refreshed hash 1, got %d", hash)
+	}
+}
+
+// TestTrackersSmallLimit tests that a small max_tracked_metrics keeps all of
+// its entries although it is split across shards
+func TestTrackersSmallLimit(t *testing.T) {
+	plugin := &InfluxDBInput{}
+	trackers := map[string]dedupTracker{
+		"exact":     newExactTracker(10, 16, plugin.generateMetricKey, false),
+		"watermark": newWatermarkTracker(10, 16, plugin.generateSeriesKey, 0),
+	}
+	for name, tracker := range trackers {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			metrics := make([]MetricData, 10)
+			for idx := range metrics {
+				metrics[idx] = MetricData{
+					Name:   "cpu",
+					Tags:   map[string]string{"host": fmt.Sprintf("server%d", idx*idx)},
+					Fields: map[string]interface{}{"usage": 1.0},
+					Time:   now.Add(time.Duration(idx) * time.Second),
+				}
+				tracker.markSeen(metrics[idx], now)
+			}
+			for idx, m := range metrics {
+				if tracker.isNew(m) {
+					t.Errorf("Expected metric %d to still be tracked", idx)
+				}
+			}
+			if entries := tracker.stats().Entries; entries != len(metrics) {
+				t.Errorf("Expected %d entries, got %d", len(metrics), entries)
+			}
+		})
+	}
+}
+
+// TestMetricTrackerRemoveOlderThan tests that expiry stops at the first recent entry
+func TestMetricTrackerRemoveOlderThan(t *testing.T) {
+	tracker := newMetricTracker(0)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		tracker.add(fmt.Sprintf("metric_%d", i), 0, now.Add(time.Duration(i)*time.Minute))
+	}
+
+	removed := tracker.removeOlderThan(now.Add(5 * time.Minute))
+	if removed != 5 {
+		t.Errorf("Expected 5 removed entries, got %d", removed)
+	}
+	if tracker.len() != 5 {
+		t.Errorf("Expected 5 remaining entries, got %d", tracker.len())
+	}
+}
+
+// TestTrackersConcurrentObserve tests that every metric is reported as new
+// exactly once when many goroutines observe it concurrently, while cleanups
+// and stats run in parallel. Run with -race to detect data races.
+func TestTrackersConcurrentObserve(t *testing.T) {
+	plugin := &InfluxDBInput{}
+	now := time.Now()
+
+	trackers := map[string]dedupTracker{
+		"exact":     newExactTracker(0, 16, plugin.generateMetricKey, false),
+		"reemit":    newExactTracker(0, 16, plugin.generateMetricKey, true),
+		"watermark": newWatermarkTracker(0, 16, plugin.generateMetricKey, time.Minute),
+		"bloom":     newBloomTracker(plugin.generateMetricKey, time.Hour, 4, 100000, 0.000001, now),
+	}
+
+	for name, tracker := range trackers {
+		t.Run(name, func(t *testing.T) {
+			const workers, metrics = 8, 500
+
+			var newCount atomic.Int64
+			var wg sync.WaitGroup
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < metrics; i++ {
+						m := MetricData{
+							Name:   fmt.Sprintf("metric_%d", i),
+							Fields: map[string]interface{}{"value": 1.0},
+							Time:   now,
+						}
+						if tracker.observe(m, now) {
+							newCount.Add(1)
+						}
+					}
+				}()
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					tracker.removeOlderThan(now.Add(-time.Hour))
+					tracker.stats()
+				}
+			}()
+
+			wg.Wait()
+
+			if newCount.Load() != metrics {
+				t.Errorf("Expected %d new metrics, got %d", metrics, newCount.Load())
+			}
+		})
+	}
+}
+
+// BenchmarkMetricTrackerAdd measures inserting into a full tracker, which
+// evicts one entry per insert. The cost per operation should stay flat as the
+// tracker grows.
+func BenchmarkMetricTrackerAdd(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tracker := newMetricTracker(size)
+			now := time.Now()
+			for i := 0; i < size; i++ {
+				tracker.add(fmt.Sprintf("prefill_%d", i), 0, now)
+			}
+
+			keys := make([]string, b.N)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("metric_%d", i)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tracker.add(keys[i], 0, now)
+			}
+		})
+	}
+}
+
+// BenchmarkMetricTrackerLookup measures lookups against a full tracker
+func BenchmarkMetricTrackerLookup(b *testing.B) {
+	for _, size := range []int{1_000, 100_000, 1_000_000} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			tracker := newMetricTracker(size)
+			now := time.Now()
+			keys := make([]string, size)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("metric_%d", i)
+				tracker.add(keys[i], 0, now)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				tracker.lookup(keys[i%size])
+			}
+		})
+	}
+}
+
+// TestMetricTrackerMemoryUsage tests the memory estimate follows the key sizes
+func TestMetricTrackerMemoryUsage(t *testing.T) {
+	tracker := newMetricTracker(0)
+	now := time.Now()
+
+	tracker.add("0123456789", 0, now)
+	tracker.add("abc", 0, now)
+
+	expected := 2*trackedMetricOverhead + 13
+	if tracker.memoryUsage() != expected {
+		t.Errorf("Expected %d bytes, got %d", expected, tracker.memoryUsage())
+	}
+
+	tracker.removeOlderThan(now.Add(time.Second))
+	if tracker.memoryUsage() != 0 {
+		t.Errorf("Expected 0 bytes after removing all entries, got %d", tracker.memoryUsage())
+	}
+}
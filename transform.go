@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/filter"
+)
+
+// TransformRule is a single step of the transform pipeline. Every rule is
+// scoped to the tag and field columns matching one of its Columns globs and
+// applies its actions in a fixed order: drop_if_match, pattern/replacement,
+// mapping, scale/offset, case and finally rename.
+type TransformRule struct {
+	Columns     []string               `toml:"columns"`
+	DropIfMatch string                 `toml:"drop_if_match"`
+	Pattern     string                 `toml:"pattern"`
+	Replacement string                 `toml:"replacement"`
+	Mapping     map[string]interface{} `toml:"mapping"`
+	Scale       *float64               `toml:"scale"` // Unset keeps the value, unlike 0
+	Offset      float64                `toml:"offset"`
+	Case        string                 `toml:"case"`
+	Rename      string                 `toml:"rename"`
+
+	columns filter.Filter
+	drop    *regexp.Regexp
+	pattern *regexp.Regexp
+
+	log             telegraf.Logger // Optional, set by the plugin
+	conflictWarning *sync.Once      // Rename conflicts are logged once per rule
+}
+
+// init validates the rule and compiles its globs and regular expressions
+func (r *TransformRule) init() error {
+	if len(r.Columns) == 0 {
+		return fmt.Errorf("no columns specified")
+	}
+
+	var err error
+	r.columns, err = filter.Compile(r.Columns)
+	if err != nil {
+		return fmt.Errorf("invalid columns: %w", err)
+	}
+
+	if r.DropIfMatch != "" {
+		r.drop, err = regexp.Compile(r.DropIfMatch)
+		if err != nil {
+			return fmt.Errorf("invalid drop_if_match: %w", err)
+		}
+	}
+
+	if r.Pattern != "" {
+		r.pattern, err = regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+	} else if r.Replacement != "" {
+		return fmt.Errorf("replacement requires a pattern")
+	}
+
+	switch r.Case {
+	case "", "lower", "upper":
+	default:
+		return fmt.Errorf("invalid case %q, expected \"lower\" or \"upper\"", r.Case)
+	}
+
+	// Several columns renamed to one name would overwrite each other
+	if r.Rename != "" && (len(r.Columns) != 1 || strings.ContainsAny(r.Columns[0], "*?[")) {
+		return fmt.Errorf("rename requires a single column without wildcards, got %v", r.Columns)
+	}
+	r.conflictWarning = &sync.Once{}
+
+	return nil
+}
+
+// apply runs the rule against m in place. It returns false when the metric
+// matched drop_if_match and has to be discarded.
+func (r *TransformRule) apply(m *MetricData) bool {
+	// Collect matching columns up front so renaming does not affect iteration
+	columns := make([]string, 0, len(m.Tags)+len(m.Fields))
+	for key := range m.Tags {
+		if r.columns.Match(key) {
+			columns = append(columns, key)
+		}
+	}
+	for key := range m.Fields {
+		if r.columns.Match(key) {
+			columns = append(columns, key)
+		}
+	}
+	sort.Strings(columns)
+
+	for _, key := range columns {
+		value, isTag := m.Tags[key]
+		var fieldValue interface{} = value
+		if !isTag {
+			fieldValue = m.Fields[key]
+		}
+
+		if r.drop != nil && r.drop.MatchString(fmt.Sprint(fieldValue)) {
+			return false
+		}
+
+		fieldValue = r.transformValue(fieldValue)
+
+		// A rename never overwrites another column, the column keeps its name
+		name := key
+		if r.Rename != "" && r.Rename != key {
+			_, tagExists := m.Tags[r.Rename]
+			_, fieldExists := m.Fields[r.Rename]
+			if !tagExists && !fieldExists {
+				name = r.Rename
+			} else if r.log != nil {
+				r.conflictWarning.Do(func() {
+					r.log.Warnf("Not renaming column %q of %q, column %q already exists", key, m.Name, r.Rename)
+				})
+			}
+		}
+
+		delete(m.Tags, key)
+		delete(m.Fields, key)
+
+		// Tags mapped to a non-string value turn into fields
+		if strVal, ok := fieldValue.(string); ok && isTag {
+			m.Tags[name] = strVal
+		} else {
+			m.Fields[name] = fieldValue
+		}
+	}
+
+	return true
+}
+
+// transformValue applies the value-level actions of the rule to a single value
+func (r *TransformRule) transformValue(value interface{}) interface{} {
+	if strVal, ok := value.(string); ok && r.pattern != nil {
+		value = r.pattern.ReplaceAllString(strVal, r.Replacement)
+	}
+
+	if len(r.Mapping) > 0 {
+		if mapped, ok := r.Mapping[fmt.Sprint(value)]; ok {
+			value = mapped
+		}
+	}
+
+	if r.Scale != nil || r.Offset != 0 {
+		scale := 1.0
+		if r.Scale != nil {
+			scale = *r.Scale
+		}
+		switch v := value.(type) {
+		case float64:
+			value = v*scale + r.Offset
+		case int64:
+			value = float64(v)*scale + r.Offset
+		}
+	}
+
+	if strVal, ok := value.(string); ok {
+		switch r.Case {
+		case "lower":
+			value = strings.ToLower(strVal)
+		case "upper":
+			value = strings.ToUpper(strVal)
+		}
+	}
+
+	return value
+}
+
+// applyTransforms runs the transform pipeline on m. It returns false when the
+// metric was dropped by one of the rules.
+func (i *InfluxDBInput) applyTransforms(m *MetricData) bool {
+	for idx := range i.Transforms {
+		if !i.Transforms[idx].apply(m) {
+			return false
+		}
+	}
+	return true
+}
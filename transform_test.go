@@ -0,0 +1,179 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// scale returns a pointer to a scale factor
+func scale(v float64) *float64 {
+	return &v
+}
+
+// TestTransformScaleAndRename tests linear scaling combined with renaming
+func TestTransformScaleAndRename(t *testing.T) {
+	plugin := &InfluxDBInput{
+		Transforms: []TransformRule{
+			{Columns: []string{"value"}, Scale: scale(0.1), Offset: -40, Rename: "temperature_c"},
+		},
+	}
+	if err := plugin.Transforms[0].init(); err != nil {
+		t.Fatalf("Unexpected error compiling rule: %v", err)
+	}
+
+	m := &MetricData{
+		Name:   "sensors",
+		Tags:   map[string]string{"host": "server1"},
+		Fields: map[string]interface{}{"value": 650.0, "other": 1.0},
+		Time:   time.Now(),
+	}
+
+	if !plugin.applyTransforms(m) {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	if _, exists := m.Fields["value"]; exists {
+		t.Error("Expected field 'value' to be renamed")
+	}
+	if m.Fields["temperature_c"] != 25.0 {
+		t.Errorf("Expected temperature_c=25, got %v", m.Fields["temperature_c"])
+	}
+	if m.Fields["other"] != 1.0 {
+		t.Errorf("Expected unmatched field to be untouched, got %v", m.Fields["other"])
+	}
+}
+
+// TestTransformStringRules tests regex replacement, case changes and mappings
+func TestTransformStringRules(t *testing.T) {
+	plugin := &InfluxDBInput{
+		Transforms: []TransformRule{
+			{Columns: []string{"host"}, Pattern: "^SRV-(.*)$", Replacement: "server-${1}", Case: "lower"},
+			{Columns: []string{"state"}, Mapping: map[string]interface{}{"ON": int64(1), "OFF": int64(0)}},
+		},
+	}
+	for idx := range plugin.Transforms {
+		if err := plugin.Transforms[idx].init(); err != nil {
+			t.Fatalf("Unexpected error compiling rule %d: %v", idx, err)
+		}
+	}
+
+	m := &MetricData{
+		Name:   "machines",
+		Tags:   map[string]string{"host": "SRV-A1", "state": "ON"},
+		Fields: map[string]interface{}{"value": 1.0},
+		Time:   time.Now(),
+	}
+
+	if !plugin.applyTransforms(m) {
+		t.Fatal("Expected metric to be kept")
+	}
+
+	if m.Tags["host"] != "server-a1" {
+		t.Errorf("Expected tag host='server-a1', got '%s'", m.Tags["host"])
+	}
+
+	// Tags mapped to a numeric value become fields
+	if _, exists := m.Tags["state"]; exists {
+		t.Error("Expected tag 'state' to be converted to a field")
+	}
+	if m.Fields["state"] != int64(1) {
+		t.Errorf("Expected field state=1, got %v", m.Fields["state"])
+	}
+}
+
+// TestTransformDropIfMatch tests that matching rows are dropped
+func TestTransformDropIfMatch(t *testing.T) {
+	plugin := &InfluxDBInput{
+		Transforms: []TransformRule{
+			{Columns: []string{"*_raw"}, DropIfMatch: "^-999$"},
+		},
+	}
+	if err := plugin.Transforms[0].init(); err != nil {
+		t.Fatalf("Unexpected error compiling rule: %v", err)
+	}
+
+	dropped := &MetricData{
+		Name:   "adc",
+		Tags:   map[string]string{},
+		Fields: map[string]interface{}{"ch1_raw": -999.0},
+	}
+	if plugin.applyTransforms(dropped) {
+		t.Error("Expected metric with sentinel value to be dropped")
+	}
+
+	kept := &MetricData{
+		Name:   "adc",
+		Tags:   map[string]string{},
+		Fields: map[string]interface{}{"ch1_raw": 512.0},
+	}
+	if !plugin.applyTransforms(kept) {
+		t.Error("Expected metric without sentinel value to be kept")
+	}
+}
+
+// TestTransformRuleValidation tests that invalid rules are rejected
+func TestTransformRuleValidation(t *testing.T) {
+	invalid := []TransformRule{
+		{},
+		{Columns: []string{"value"}, Pattern: "("},
+		{Columns: []string{"value"}, Replacement: "x"},
+		{Columns: []string{"value"}, Case: "title"},
+		{Columns: []string{"*_raw"}, Rename: "raw"},
+		{Columns: []string{"a", "b"}, Rename: "c"},
+	}
+
+	for idx, rule := range invalid {
+		if err := rule.init(); err == nil {
+			t.Errorf("Expected error for invalid rule %d", idx)
+		}
+	}
+}
+
+// TestTransformZeroScale tests that an explicit zero scale is applied
+func TestTransformZeroScale(t *testing.T) {
+	plugin := &InfluxDBInput{
+		Transforms: []TransformRule{
+			{Columns: []string{"value"}, Scale: scale(0), Offset: 5},
+			{Columns: []string{"other"}, Offset: 5},
+		},
+	}
+	for idx := range plugin.Transforms {
+		if err := plugin.Transforms[idx].init(); err != nil {
+			t.Fatalf("Unexpected error compiling rule %d: %v", idx, err)
+		}
+	}
+
+	m := &MetricData{Name: "m", Tags: map[string]string{}, Fields: map[string]interface{}{"value": 10.0, "other": 10.0}}
+	plugin.applyTransforms(m)
+	if m.Fields["value"] != 5.0 {
+		t.Errorf("Expected value=5 with scale 0, got %v", m.Fields["value"])
+	}
+	if m.Fields["other"] != 15.0 {
+		t.Errorf("Expected other=15 without scale, got %v", m.Fields["other"])
+	}
+}
+
+// TestTransformRenameConflict tests that a rename does not overwrite an
+// existing column
+func TestTransformRenameConflict(t *testing.T) {
+	var log strings.Builder
+	plugin := &InfluxDBInput{
+		Transforms: []TransformRule{
+			{Columns: []string{"value"}, Rename: "temperature"},
+		},
+	}
+	plugin.Transforms[0].log = &simpleLogger{output: &log}
+	if err := plugin.Transforms[0].init(); err != nil {
+		t.Fatalf("Unexpected error compiling rule: %v", err)
+	}
+
+	m := &MetricData{Name: "sensors", Tags: map[string]string{}, Fields: map[string]interface{}{"value": 1.0, "temperature": 2.0}}
+	plugin.applyTransforms(m)
+	if m.Fields["value"] != 1.0 || m.Fields["temperature"] != 2.0 {
+		t.Errorf("Expected both columns to be kept, got %v", m.Fields)
+	}
+	if !strings.Contains(log.String(), `column "temperature" already exists`) {
+		t.Errorf("Expected rename conflict in log, got %q", log.String())
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// seriesWatermarkOverhead is the approximate number of bytes used per tracked
+// series in addition to its key and the remembered late timestamps
+const seriesWatermarkOverhead = 176
+
+// watermarkTracker deduplicates append-only series by remembering only the
+// newest timestamp of every series. Points at or before that watermark are
+// dropped, except for points within the allowed lateness which are forwarded
+// once; their timestamps are remembered until the watermark moves past them.
+// Series are spread over independently locked shards.
+type watermarkTracker struct {
+	key      func(MetricData) string
+	lateness time.Duration
+	shards   []*watermarkShard
+}
+
+// watermarkShard holds the series of one shard ordered by their last update
+type watermarkShard struct {
+	mu        sync.RWMutex
+	maxSize   int
+	series    map[string]*list.Element
+	order     *list.List // Front is the least recently updated series
+	keyBytes  int
+	lateCount int
+	evictions uint64
+}
+
+// seriesWatermark is the state of a single series
+type seriesWatermark struct {
+	key       string
+	watermark time.Time
+	updated   time.Time
+	late      map[int64]struct{} // Timestamps seen within the lateness window
+}
+
+// newWatermarkTracker creates a watermark tracker holding about maxSize
+// series identified by key, spread over the given number of shards
+func newWatermarkTracker(maxSize, shards int, key func(MetricData) string, lateness time.Duration) *watermarkTracker {
+	shards = shardCount(maxSize, shards)
+	t := &watermarkTracker{
+		key:      key,
+		lateness: lateness,
+		shards:   make([]*watermarkShard, shards),
+	}
+	for idx := range t.shards {
+		t.shards[idx] = &watermarkShard{
+			maxSize: shardSize(maxSize, shards),
+			series:  make(map[string]*list.Element),
+			order:   list.New(),
+		}
+	}
+
+	return t
+}
+
+// shard returns the shard responsible for key
+func (t *watermarkTracker) shard(key string) *watermarkShard {
+	return t.shards[shardIndex(key, len(t.shards))]
+}
+
+// isNew checks if a metric is newer than the watermark of its series or an
+// unseen out-of-order point within the allowed lateness
+func (t *watermarkTracker) isNew(m MetricData) bool {
+	key := t.key(m)
+	shard := t.shard(key)
+
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	return shard.isNew(key, m.Time, t.lateness)
+}
+
+// markSeen advances the watermark of the metric's series
+func (t *watermarkTracker) markSeen(m MetricData, now time.Time) {
+	key := t.key(m)
+	shard := t.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.markSeen(key, m.Time, now, t.lateness)
+}
+
+// observe atomically checks and records a metric
+func (t *watermarkTracker) observe(m MetricData, now time.Time) bool {
+	key := t.key(m)
+	shard := t.shard(key)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if !shard.isNew(key, m.Time, t.lateness) {
+		return false
+	}
+	shard.markSeen(key, m.Time, now, t.lateness)
+	return true
+}
+
+// removeOlderThan drops all series not updated since cutoff and returns the
+// number of removed series
+func (t *watermarkTracker) removeOlderThan(cutoff time.Time) int {
+	removed := 0
+	for _, shard := range t.shards {
+		removed += shard.removeOlderThan(cutoff)
+	}
+	return removed
+}
+
+// stats returns a summary of the tracker state
+func (t *watermarkTracker) stats() trackerStats {
+	var stats trackerStats
+	for _, shard := range t.shards {
+		shard.mu.RLock()
+		stats.Entries += shard.order.Len()
+		stats.Evictions += shard.evictions
+		stats.MemoryBytes += shard.order.Len()*seriesWatermarkOverhead + shard.keyBytes + shard.lateCount*16
+		shard.mu.RUnlock()
+	}
+	return stats
+}
+
+// isNew checks a timestamp against the series watermark, the caller must
+// hold the read lock
+func (s *watermarkShard) isNew(key string, ts time.Time, lateness time.Duration) bool {
+	elem, exists := s.series[key]
+	if !exists {
+		return true
+	}
+
+	state := elem.Value.(*seriesWatermark)
+	if ts.After(state.watermark) {
+		return true
+	}
+	if !ts.After(state.watermark.Add(-lateness)) {
+		return false
+	}
+
+	_, seen := state.late[ts.UnixNano()]
+	return !seen
+}
+
+// markSeen records a timestamp for the series, the caller must hold the
+// write lock
+func (s *watermarkShard) markSeen(key string, ts, now time.Time, lateness time.Duration) {
+	var state *seriesWatermark
+	if elem, exists := s.series[key]; exists {
+		state = elem.Value.(*seriesWatermark)
+		s.order.MoveToBack(elem)
+	} else {
+		state = &seriesWatermark{key: key, watermark: ts}
+		s.series[key] = s.order.PushBack(state)
+		s.keyBytes += len(key)
+	}
+	state.updated = now
+
+	advanced := ts.After(state.watermark)
+	if advanced {
+		state.watermark = ts
+	}
+
+	if lateness > 0 {
+		if state.late == nil {
+			state.late = make(map[int64]struct{})
+		}
+
+		// Forget timestamps the watermark has moved past
+		cutoff := state.watermark.Add(-lateness)
+		if advanced {
+			for late := range state.late {
+				if !time.Unix(0, late).After(cutoff) {
+					delete(state.late, late)
+					s.lateCount--
+				}
+			}
+		}
+
+		if _, seen := state.late[ts.UnixNano()]; !seen && ts.After(cutoff) {
+			state.late[ts.UnixNano()] = struct{}{}
+			s.lateCount++
+		}
+	}
+
+	for s.maxSize > 0 && s.order.Len() > s.maxSize {
+		s.removeElement(s.order.Front())
+		s.evictions++
+	}
+}
+
+// removeOlderThan drops all series of the shard not updated since cutoff
+func (s *watermarkShard) removeOlderThan(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for elem := s.order.Front(); elem != nil; elem = s.order.Front() {
+		if !elem.Value.(*seriesWatermark).updated.Before(cutoff) {
+			break
+		}
+		s.removeElement(elem)
+		removed++
+	}
+
+	return removed
+}
+
+// removeElement unlinks a series, the caller must hold the write lock
+func (s *watermarkShard) removeElement(elem *list.Element) {
+	state := elem.Value.(*seriesWatermark)
+	s.order.Remove(elem)
+	delete(s.series, state.key)
+	s.keyBytes -= len(state.key)
+	s.lateCount -= len(state.late)
+}
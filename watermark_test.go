@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestWatermarkPlugin creates an initialized plugin using the watermark strategy
+func newTestWatermarkPlugin(t *testing.T, lateness string) *InfluxDBInput {
+	plugin := withConnection(&InfluxDBInput{
+		TrackNewMetricsOnly: true,
+		DedupStrategy:       "watermark",
+		AllowedLateness:     lateness,
+		Log:                 &simpleLogger{},
+	})
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Unexpected error initializing plugin: %v", err)
+	}
+	return plugin
+}
+
+// TestWatermarkDropsOldPoints tests that points at or before the watermark are dropped
+func TestWatermarkDropsOldPoints(t *testing.T) {
+	plugin := newTestWatermarkPlugin(t, "")
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	point := func(host string, offset time.Duration) MetricData {
+		return MetricData{
+			Name:   "test_metric",
+			Tags:   map[string]string{"host": host},
+			Fields: map[string]interface{}{"value": 1.0},
+			Time:   base.Add(offset),
+		}
+	}
+
+	plugin.markMetricAsSeen(point("server1", time.Minute))
+
+	if plugin.isNewMetric(point("server1", time.Minute)) {
+		t.Error("Expected point at the watermark to be dropped")
+	}
+	if plugin.isNewMetric(point("server1", 0)) {
+		t.Error("Expected point before the watermark to be dropped")
+	}
+	if !plugin.isNewMetric(point("server1", 2*time.Minute)) {
+		t.Error("Expected point after the watermark to be new")
+	}
+	if !plugin.isNewMetric(point("server2", 0)) {
+		t.Error("Expected point of another series to be new")
+	}
+
+	// Only one entry per series is kept
+	plugin.markMetricAsSeen(point("server1", 2*time.Minute))
+	if entries := plugin.tracker.stats().Entries; entries != 1 {
+		t.Errorf("Expected 1 tracked series, got %d", entries)
+	}
+}
+
+// TestWatermarkAllowedLateness tests that late points are forwarded exactly once
+func TestWatermarkAllowedLateness(t *testing.T) {
+	plugin := newTestWatermarkPlugin(t, "5m")
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	point := func(offset time.Duration) MetricData {
+		return MetricData{
+			Name:   "test_metric",
+			Tags:   map[string]string{"host": "server1"},
+			Fields: map[string]interface{}{"value": 1.0},
+			Time:   base.Add(offset),
+		}
+	}
+
+	plugin.markMetricAsSeen(point(10 * time.Minute))
+
+	late := point(7 * time.Minute)
+	if !plugin.isNewMetric(late) {
+		t.Error("Expected late point within allowed lateness to be new")
+	}
+	plugin.markMetricAsSeen(late)
+	if plugin.isNewMetric(late) {
+		t.Error("Expected late point to be dropped once seen")
+	}
+
+	if plugin.isNewMetric(point(10 * time.Minute)) {
+		t.Error("Expected point at the watermark to be dropped")
+	}
+	if plugin.isNewMetric(point(4 * time.Minute)) {
+		t.Error("Expected point beyond allowed lateness to be dropped")
+	}
+
+	// Moving the watermark forgets late timestamps that fall out of the window
+	plugin.markMetricAsSeen(point(20 * time.Minute))
+	if plugin.isNewMetric(late) {
+		t.Error("Expected point beyond allowed lateness of the new watermark to be dropped")
+	}
+	if !plugin.isNewMetric(point(16 * time.Minute)) {
+		t.Error("Expected unseen late point within the new window to be new")
+	}
+}
+
+// TestWatermarkDescendingRows tests that a query ordered by time DESC emits
+// every new point of a series, not only the newest one
+func TestWatermarkDescendingRows(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[
+			{"time": "2024-01-01T12:00:20Z", "host": "server1", "value": 3},
+			{"time": "2024-01-01T12:00:10Z", "host": "server1", "value": 2},
+			{"time": "2024-01-01T12:00:00Z", "host": "server1", "value": 1}
+		]`))
+	}))
+	defer server.Close()
+
+	plugin := withConnection(&InfluxDBInput{
+		QueryName:           t.Name(),
+		TrackNewMetricsOnly: true,
+		DedupStrategy:       "watermark",
+		Log:                 &simpleLogger{},
+	})
+	plugin.URL = server.URL
+	plugin.Query = "SELECT * FROM metrics ORDER BY time DESC LIMIT 100"
+	if err := plugin.Init(); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+
+	acc := &simpleAccumulator{}
+	for range 2 {
+		if err := plugin.Gather(acc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if len(acc.metrics) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(acc.metrics))
+	}
+	for idx, m := range acc.metrics {
+		if value, _ := m.GetField("value"); value != float64(idx+1) {
+			t.Errorf("Expected metric %d to have value %d, got %v", idx, idx+1, value)
+		}
+	}
+}
+
+// TestWatermarkExpiry tests that series not updated within the window expire
+func TestWatermarkExpiry(t *testing.T) {
+	tracker := newWatermarkTracker(0, 4, func(m MetricData) string { return m.Name }, 0)
+
+	now := time.Now()
+	tracker.markSeen(MetricData{Name: "stale", Time: now}, now.Add(-2*time.Hour))
+	tracker.markSeen(MetricData{Name: "active", Time: now}, now)
+
+	if removed := tracker.removeOlderThan(now.Add(-time.Hour)); removed != 1 {
+		t.Errorf("Expected 1 expired series, got %d", removed)
+	}
+	if !tracker.isNew(MetricData{Name: "stale", Time: now}) {
+		t.Error("Expected expired series to be forgotten")
+	}
+	if tracker.isNew(MetricData{Name: "active", Time: now}) {
+		t.Error("Expected active series to still be tracked")
+	}
+}
+
+// TestWatermarkValidation tests invalid watermark settings
+func TestWatermarkValidation(t *testing.T) {
+	plugin := withConnection(&InfluxDBInput{DedupStrategy: "watermark", DedupMode: "content"})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for content mode with watermark strategy")
+	}
+
+	plugin = withConnection(&InfluxDBInput{DedupStrategy: "watermark", AllowedLateness: "soon"})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for invalid allowed_lateness")
+	}
+
+	plugin = withConnection(&InfluxDBInput{DedupStrategy: "newest"})
+	if err := plugin.Init(); err == nil {
+		t.Error("Expected error for invalid dedup_strategy")
+	}
+}